@@ -0,0 +1,228 @@
+//go:build !windows
+
+// entrypoint is a small, static, Tekton-style entrypoint binary. It is
+// shipped in the frontend image and mounted read-only into each step, so that
+// timeouts, onError, exit-code files, stdout/stderr redirection and result
+// size limits work without relying on a shell (or any tool) in the step image.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	continueOnError = "continue"
+	// timeoutExitCode is the exit code used when a step times out, the same as
+	// GNU timeout (which was used before the entrypoint).
+	timeoutExitCode = 124
+
+	// resultsFromSidecarLogs is the results-from value (of the Tekton
+	// feature-flags) limiting the size of each result, instead of the size
+	// of all the results (termination-message).
+	resultsFromSidecarLogs = "sidecar-logs"
+)
+
+var (
+	timeout         = flag.Duration("timeout", 0, "If specified, kill the step after this duration")
+	onError         = flag.String("on_error", "", "Set to \"continue\" to ignore a non-zero exit code of the step")
+	stepMetadataDir = flag.String("step_metadata_dir", "", "If specified, write the step exit code in this directory")
+	stdoutPath      = flag.String("stdout_path", "", "If specified, also write the step stdout to this file")
+	stderrPath      = flag.String("stderr_path", "", "If specified, also write the step stderr to this file")
+	resultsDir      = flag.String("results_dir", "", "The directory the step results are written in")
+	clearResults    = flag.Bool("clear_results", false, "If set, remove the results left in results_dir (e.g. by a previous run) before running the step")
+	resultsFrom     = flag.String("results_from", "", "How results are extracted (termination-message or sidecar-logs), which defines what max_result_size limits")
+	maxResultSize   = flag.Int("max_result_size", 0, "If specified, the maximum size of the results (termination-message) or of each result (sidecar-logs) in results_dir")
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "entrypoint: no command to run")
+		os.Exit(1)
+	}
+	os.Exit(entrypoint(args))
+}
+
+// entrypoint runs the step command and returns the exit code of the step.
+func entrypoint(args []string) int {
+	if *clearResults && *resultsDir != "" {
+		// Like the exit code, this doesn't fail the step (e.g. a step running
+		// as a user that cannot write the results).
+		if err := clearDir(*resultsDir); err != nil {
+			fmt.Fprintf(os.Stderr, "entrypoint: warning: cannot clear the previous results: %v\n", err)
+		}
+	}
+	exitCode, err := run(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "entrypoint: %v\n", err)
+	}
+	if exitCode == 0 && *resultsDir != "" && *maxResultSize > 0 {
+		if err := checkResults(*resultsDir, *resultsFrom, *maxResultSize); err != nil {
+			fmt.Fprintf(os.Stderr, "entrypoint: %v\n", err)
+			exitCode = 1
+		}
+	}
+	if *stepMetadataDir != "" {
+		// The exit code is only metadata (e.g. for $(steps.<name>.exitCode.path)),
+		// it doesn't fail the step, e.g. when it runs as a user that cannot write it.
+		if err := writeExitCode(*stepMetadataDir, exitCode); err != nil {
+			fmt.Fprintf(os.Stderr, "entrypoint: warning: cannot write the exit code: %v\n", err)
+		}
+	}
+	if exitCode != 0 && *onError == continueOnError {
+		fmt.Fprintf(os.Stderr, "entrypoint: ignoring exit code %d (onError: continue)\n", exitCode)
+		return 0
+	}
+	return exitCode
+}
+
+// run executes the given command, forwarding signals to it, and returns its exit code.
+func run(args []string) (int, error) {
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	// Don't wait forever on output pipes held by orphaned children once killed
+	cmd.WaitDelay = 5 * time.Second
+
+	stdout, closeStdout, err := output(os.Stdout, *stdoutPath)
+	if err != nil {
+		return 1, err
+	}
+	defer closeStdout()
+	stderr, closeStderr, err := output(os.Stderr, *stderrPath)
+	if err != nil {
+		return 1, err
+	}
+	defer closeStderr()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return startExitCode(err), err
+	}
+
+	// Only the terminating signals are forwarded, not the ones the Go runtime
+	// uses itself (e.g. SIGURG) or the step gets from its own children.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return timeoutExitCode, fmt.Errorf("step timed out after %s", *timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// startExitCode returns the exit code of a command that failed to start, like
+// shells do: 126 when it is found but cannot be executed, 127 otherwise.
+func startExitCode(err error) int {
+	if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOEXEC) {
+		return 126
+	}
+	return 127
+}
+
+// output returns a writer writing to w and, if path is not empty, to the file at path.
+func output(w io.Writer, path string) (io.Writer, func(), error) {
+	if path == "" {
+		return w, func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.MultiWriter(w, f), func() { f.Close() }, nil
+}
+
+// checkResults checks the size of the results written in dir, like Tekton
+// does when extracting them: with sidecar-logs each result is limited to
+// maxSize, otherwise all the results share the termination message.
+func checkResults(dir, from string, maxSize int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	total := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		size := int(info.Size())
+		if from == resultsFromSidecarLogs && size > maxSize {
+			return fmt.Errorf("result %s is %d bytes, larger than the max-result-size of %d bytes", e.Name(), size, maxSize)
+		}
+		total += size
+	}
+	if from != resultsFromSidecarLogs && total > maxSize {
+		return fmt.Errorf("results are %d bytes, above the max allowed termination message size of %d bytes", total, maxSize)
+	}
+	return nil
+}
+
+// clearDir removes the content of dir, keeping dir itself (e.g. a mount).
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExitCode(dir string, exitCode int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "exitCode"), []byte(strconv.Itoa(exitCode)), 0o644)
+}
@@ -0,0 +1,195 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withFlags sets the flags of the entrypoint for a test.
+func withFlags(t *testing.T, set func()) {
+	t.Helper()
+	saved := []interface{}{*timeout, *onError, *stepMetadataDir, *stdoutPath, *stderrPath, *resultsDir, *resultsFrom, *maxResultSize, *clearResults}
+	t.Cleanup(func() {
+		*timeout = saved[0].(time.Duration)
+		*onError = saved[1].(string)
+		*stepMetadataDir = saved[2].(string)
+		*stdoutPath = saved[3].(string)
+		*stderrPath = saved[4].(string)
+		*resultsDir = saved[5].(string)
+		*resultsFrom = saved[6].(string)
+		*maxResultSize = saved[7].(int)
+		*clearResults = saved[8].(bool)
+	})
+	set()
+}
+
+func readExitCode(t *testing.T, dir string) string {
+	t.Helper()
+	dt, err := os.ReadFile(filepath.Join(dir, "exitCode"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dt)
+}
+
+func TestEntrypoint_ExitCode(t *testing.T) {
+	bin := t.TempDir()
+	notExecutable := filepath.Join(bin, "not-executable")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\ntrue\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	badFormat := filepath.Join(bin, "bad-format")
+	if err := os.WriteFile(badFormat, []byte("not a binary\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "success", args: []string{"true"}, expected: 0},
+		{name: "failure", args: []string{"sh", "-c", "exit 3"}, expected: 3},
+		{name: "signaled", args: []string{"sh", "-c", "kill -TERM $$"}, expected: 128 + 15},
+		{name: "not found", args: []string{"/does/not/exist"}, expected: 127},
+		{name: "not executable", args: []string{notExecutable}, expected: 126},
+		{name: "exec format error", args: []string{badFormat}, expected: 126},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			withFlags(t, func() { *stepMetadataDir = dir })
+			if code := entrypoint(tc.args); code != tc.expected {
+				t.Errorf("expected exit code %d, got %d", tc.expected, code)
+			}
+			if code := readExitCode(t, dir); code != strconv.Itoa(tc.expected) {
+				t.Errorf("expected exitCode file %d, got %s", tc.expected, code)
+			}
+		})
+	}
+}
+
+func TestEntrypoint_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, func() {
+		*timeout = 100 * time.Millisecond
+		*stepMetadataDir = dir
+	})
+	start := time.Now()
+	if code := entrypoint([]string{"sleep", "10"}); code != timeoutExitCode {
+		t.Errorf("expected exit code %d, got %d", timeoutExitCode, code)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("step was not killed on timeout, took %s", d)
+	}
+	if code := readExitCode(t, dir); code != strconv.Itoa(timeoutExitCode) {
+		t.Errorf("expected exitCode file %d, got %s", timeoutExitCode, code)
+	}
+}
+
+func TestEntrypoint_OnErrorContinue(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, func() {
+		*onError = continueOnError
+		*stepMetadataDir = dir
+	})
+	if code := entrypoint([]string{"sh", "-c", "exit 2"}); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	// The exit code of the step is still recorded
+	if code := readExitCode(t, dir); code != "2" {
+		t.Errorf("expected exitCode file 2, got %s", code)
+	}
+}
+
+func TestEntrypoint_UnwritableMetadata(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "steps")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The exit code cannot be written (steps is a file), the step succeeds anyway
+	withFlags(t, func() { *stepMetadataDir = filepath.Join(dir, "step-build") })
+	if code := entrypoint([]string{"true"}); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+}
+
+func TestEntrypoint_OutputTee(t *testing.T) {
+	dir := t.TempDir()
+	stdout := filepath.Join(dir, "out", "stdout")
+	stderr := filepath.Join(dir, "err", "stderr")
+	withFlags(t, func() {
+		*stdoutPath = stdout
+		*stderrPath = stderr
+	})
+	if code := entrypoint([]string{"sh", "-c", "echo to-stdout; echo to-stderr >&2"}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	for path, expected := range map[string]string{stdout: "to-stdout\n", stderr: "to-stderr\n"} {
+		dt, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dt) != expected {
+			t.Errorf("%s: expected %q, got %q", filepath.Base(path), expected, string(dt))
+		}
+	}
+}
+
+func TestEntrypoint_ClearResults(t *testing.T) {
+	dir := t.TempDir()
+	// Results left by a previous run
+	if err := os.WriteFile(filepath.Join(dir, "digest"), []byte(strings.Repeat("x", 4096)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withFlags(t, func() {
+		*resultsDir = dir
+		*maxResultSize = 4096
+	})
+	write := []string{"sh", "-c", "printf sha256:abc > " + filepath.Join(dir, "url")}
+	if code := entrypoint(write); code != 1 {
+		t.Errorf("expected the previous results to be too large, got exit code %d", code)
+	}
+	*clearResults = true
+	if code := entrypoint(write); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "url" {
+		t.Errorf("expected only the url result, got %v", entries)
+	}
+}
+
+func TestCheckResults(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{"digest": "sha256:abc", "url": "https://example.com"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		from    string
+		maxSize int
+		wantErr bool
+	}{
+		{maxSize: 4096},
+		{maxSize: 20, wantErr: true},
+		{from: resultsFromSidecarLogs, maxSize: 20},
+		{from: resultsFromSidecarLogs, maxSize: 10, wantErr: true},
+	} {
+		err := checkResults(dir, tc.from, tc.maxSize)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkResults(%q, %d): unexpected error %v", tc.from, tc.maxSize, err)
+		}
+	}
+	if err := checkResults(filepath.Join(dir, "missing"), "", 1); err != nil {
+		t.Errorf("missing results dir should not error, got %v", err)
+	}
+}
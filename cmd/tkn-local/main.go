@@ -19,6 +19,7 @@ func Command() *cobra.Command {
 	cmd.AddCommand(
 		pruneCommand(),
 		runCommand(),
+		startCommand(),
 	)
 
 	return cmd
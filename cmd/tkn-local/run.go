@@ -8,12 +8,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/streams"
 	"github.com/moby/buildkit/client"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
 	"github.com/moby/buildkit/util/appcontext"
 	"github.com/moby/buildkit/util/progress/progresswriter"
 	"github.com/moby/term"
@@ -21,6 +24,9 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/vdemeester/buildkit-tekton/pkg/build"
 	"github.com/vdemeester/buildkit-tekton/pkg/buildkit"
+	tektonconfig "github.com/vdemeester/buildkit-tekton/pkg/config"
+	"github.com/vdemeester/buildkit-tekton/pkg/credentials"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -28,33 +34,57 @@ type runOption struct {
 	filename string
 	dirs     []string
 	host     string
+	// entitlements to allow, like buildctl --allow (e.g. network.host for
+	// sidecars, security.insecure for privileged steps)
+	allow []string
+	// host paths explicitly shared for hostPath volumes (<volume>=<path>)
+	hostPaths []string
 	// mimics buildctl opt, should control even more the UX
 	options []string
+	// run all the runs of the main file concurrently
+	all bool
+	// workspaces bound to a directory, git repository or image (<name>=<source>)
+	workspaceSources []string
 }
 
 func runCommand() *cobra.Command {
 	opts := &runOption{}
 	cmd := &cobra.Command{
-		Use:     "run",
+		Use:     "run [name]",
 		Aliases: []string{},
 		Short:   "Run a tekton resource",
+		Long:    "Run a tekton resource. When the main file has several runs, select one by name (or generateName), or all of them with --all.",
+		Args:    cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(opts)
+			return run(opts, args)
 		},
 	}
-	cmd.Flags().StringVar(&opts.host, "host", "", "Host to use")
 	cmd.Flags().StringVarP(&opts.filename, "filename", "f", "", "Main file to load")
 	cmd.Flags().StringArrayVarP(&opts.dirs, "dir", "d", []string{}, "Folder(s) to add to the context")
-	cmd.Flags().StringArrayVar(&opts.options, "opt", []string{}, "Option to pass")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Run all the runs of the main file concurrently")
+	cmd.Flags().StringArrayVarP(&opts.workspaceSources, "workspace", "w", []string{}, "Bind a workspace to a directory, git repository or image, as <name>=<dir|git url|docker-image://ref> (e.g. source=.)")
+	addBuildFlags(cmd, opts)
 
 	return cmd
 }
 
-func run(opts *runOption) error {
+// addBuildFlags adds the flags controlling the build (buildkit host, options,
+// entitlements and shared host paths).
+func addBuildFlags(cmd *cobra.Command, opts *runOption) {
+	cmd.Flags().StringVar(&opts.host, "host", "", "Host to use")
+	cmd.Flags().StringArrayVar(&opts.options, "opt", []string{}, "Option to pass")
+	cmd.Flags().StringArrayVar(&opts.allow, "allow", []string{}, "Allow extra privileged entitlement, e.g. network.host (required by sidecars) or security.insecure (required by privileged steps)")
+	cmd.Flags().StringArrayVar(&opts.hostPaths, "host-path", []string{}, "Share a host path with a hostPath volume, as <volume>=<path> (e.g. docker=/var/run/docker.sock)")
+}
+
+func run(opts *runOption, args []string) error {
 	stdin, _, _ := term.StdStreams()
 	if opts.filename == "" {
 		return errors.New("must specify -f")
 	}
+	if opts.all && len(args) > 0 {
+		return errors.New("cannot specify a run name with --all")
+	}
 	if len(opts.dirs) > 1 {
 		return errors.New("multiple -d not yet supported")
 	}
@@ -100,6 +130,47 @@ func run(opts *runOption) error {
 		dir = filepath.Dir(file.Name())
 	}
 
+	attrs := map[string]string{}
+	switch {
+	case opts.all:
+		attrs["run"] = tektonconfig.AllRuns
+	case len(args) > 0:
+		attrs["run"] = args[0]
+	}
+	locals := map[string]string{}
+	if err := workspaceContexts(opts.workspaceSources, attrs, locals); err != nil {
+		return err
+	}
+	return solve(opts, dir, dir, filename, attrs, locals)
+}
+
+// workspaceContexts binds workspaces to named contexts (<name>=<source>),
+// with the workspace option. Directories are shared as local contexts, other
+// sources (git URLs, docker-image://<ref>) are given with the context option.
+func workspaceContexts(values []string, attrs, locals map[string]string) error {
+	m, err := attrMap(values)
+	if err != nil {
+		return errors.Wrap(err, "invalid workspace")
+	}
+	for name, source := range m {
+		id := localWorkspacePrefix + name
+		attrs["workspace:"+name] = tekton.ContextBindingPrefix + id
+		if fi, err := os.Stat(source); err == nil && fi.IsDir() {
+			abs, err := filepath.Abs(source)
+			if err != nil {
+				return err
+			}
+			locals[id] = abs
+			continue
+		}
+		attrs["context:"+id] = source
+	}
+	return nil
+}
+
+// solve runs the main file (filename, in mainDir) with the given context
+// directory, frontend attributes and additional local directories.
+func solve(opts *runOption, contextDir, mainDir, filename string, attrs map[string]string, locals map[string]string) error {
 	eg, ctx := errgroup.WithContext(appcontext.Context())
 	// Connect or start buildkit
 	c, err := buildkit.NewClient(ctx, opts.host)
@@ -107,14 +178,29 @@ func run(opts *runOption) error {
 		return err
 	}
 
+	// Credentials from imagePullSecrets found by the frontend take precedence
+	// over the docker configuration file.
+	store := credentials.NewStore()
 	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(authprovider.DockerAuthProviderConfig{AuthConfigProvider: authprovider.LoadAuthConfig(dockerConfig)})}
+	attachable := []session.Attachable{authprovider.NewDockerAuthProvider(authprovider.DockerAuthProviderConfig{AuthConfigProvider: store.AuthConfigProvider(authprovider.LoadAuthConfig(dockerConfig))})}
+	localDirs := map[string]string{
+		"context":    contextDir,
+		"dockerfile": mainDir,
+	}
+	for name, dir := range locals {
+		localDirs[name] = dir
+	}
+	hostPathAttachable, err := shareHostPaths(opts.hostPaths, localDirs)
+	if err != nil {
+		return err
+	}
+	if hostPathAttachable != nil {
+		attachable = append(attachable, hostPathAttachable)
+	}
 	buildopts := client.SolveOpt{
-		LocalDirs: map[string]string{
-			"context":    dir,
-			"dockerfile": dir,
-		},
-		Session: attachable,
+		LocalDirs:           localDirs,
+		Session:             attachable,
+		AllowedEntitlements: opts.allow,
 		// CacheExports: c.cfg.CacheExports,
 		// CacheImports: c.cfg.CacheImports,
 	}
@@ -122,6 +208,9 @@ func run(opts *runOption) error {
 	if err != nil {
 		return errors.Wrap(err, "invalid opt")
 	}
+	for k, v := range attrs {
+		buildopts.FrontendAttrs[k] = v
+	}
 	buildopts.FrontendAttrs["filename"] = filename
 
 	pw, err := progresswriter.NewPrinter(context.TODO(), os.Stderr, "auto")
@@ -142,13 +231,26 @@ func run(opts *runOption) error {
 		}
 	}
 
+	sidecarWriter := mw.WithPrefix("", false)
+	writers = append(writers, sidecarWriter)
+	sidecarLogs := &progressLogs{logger: func(s *client.SolveStatus) {
+		sidecarWriter.Status() <- s
+	}}
+
 	eg.Go(func() error {
 		defer func() {
+			// Make sure all sidecar logs are written before closing the writers
+			sidecarLogs.Wait()
 			for _, w := range writers {
 				close(w.Status())
 			}
 		}()
-		r, err := c.Build(ctx, buildopts, "foo-is-bar", build.Build, progresswriter.ResetTime(mw.WithPrefix("", false)).Status())
+		buildFunc := func(ctx context.Context, c gateway.Client) (*gateway.Result, error) {
+			ctx = credentials.ToContext(ctx, store)
+			ctx = tekton.WithLogWriter(ctx, sidecarLogs.Writer)
+			return build.Build(ctx, c)
+		}
+		r, err := c.Build(ctx, buildopts, "foo-is-bar", buildFunc, progresswriter.ResetTime(mw.WithPrefix("", false)).Status())
 		if err != nil {
 			return err
 		}
@@ -165,6 +267,41 @@ func run(opts *runOption) error {
 	return eg.Wait()
 }
 
+// progressLogs streams logs (e.g. from sidecars) into the progress output, each
+// writer being displayed as its own vertex.
+type progressLogs struct {
+	logger progresswriter.Logger
+	wg     sync.WaitGroup
+}
+
+// Writer returns a writer whose content is logged under a vertex with the given name.
+// The vertex is completed when the writer is closed.
+func (p *progressLogs) Writer(name string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		_ = progresswriter.Wrap(name, p.logger, func(l progresswriter.SubLogger) error {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := pr.Read(buf)
+				if n > 0 {
+					l.Log(1, append([]byte{}, buf[:n]...))
+				}
+				if err != nil {
+					return nil
+				}
+			}
+		})
+	}()
+	return pw
+}
+
+// Wait waits for all the writers to be closed and their logs written.
+func (p *progressLogs) Wait() {
+	p.wg.Wait()
+}
+
 func parseOpt(opts []string) (map[string]string, error) {
 	m := make(map[string]string)
 	modern, err := attrMap(opts)
@@ -188,3 +325,33 @@ func attrMap(sl []string) (map[string]string, error) {
 	}
 	return m, nil
 }
+
+// shareHostPaths shares the given host paths (<volume>=<path>) for hostPath
+// volumes: directories as local contexts (added to localDirs), sockets
+// forwarded through the session (like buildctl --ssh).
+func shareHostPaths(hostPaths []string, localDirs map[string]string) (session.Attachable, error) {
+	m, err := attrMap(hostPaths)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid host-path")
+	}
+	sockets := []sshprovider.AgentConfig{}
+	for volume, p := range m {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid host-path %s", volume)
+		}
+		id := tekton.HostPathPrefix + volume
+		switch {
+		case fi.Mode()&os.ModeSocket != 0:
+			sockets = append(sockets, sshprovider.AgentConfig{ID: id, Paths: []string{p}, Raw: true})
+		case fi.IsDir():
+			localDirs[id] = p
+		default:
+			return nil, errors.Errorf("invalid host-path %s: %s is neither a directory nor a socket", volume, p)
+		}
+	}
+	if len(sockets) == 0 {
+		return nil, nil
+	}
+	return sshprovider.NewSSHAgentProvider(sockets)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton"
+	"sigs.k8s.io/yaml"
+)
+
+// localWorkspacePrefix is the prefix of the named context a workspace is
+// bound to by tkn-local (e.g. with -w source=.).
+const localWorkspacePrefix = "workspace-"
+
+type startOption struct {
+	runOption
+	params           []string
+	workspaces       []string
+	useParamDefaults bool
+}
+
+func startCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "start",
+		Aliases: []string{},
+		Short:   "Start a Task or Pipeline without writing a run",
+	}
+	cmd.AddCommand(
+		startKindCommand("task"),
+		startKindCommand("pipeline"),
+	)
+	return cmd
+}
+
+func startKindCommand(kind string) *cobra.Command {
+	opts := &startOption{}
+	cmd := &cobra.Command{
+		Use:     kind + " <name>",
+		Aliases: []string{},
+		Short:   "Start a " + kind + " from the context, like tkn " + kind + " start",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return start(opts, kind, args[0])
+		},
+	}
+	cmd.Flags().StringArrayVarP(&opts.dirs, "dir", "d", []string{}, "Folder to use as context (defaults to the current directory)")
+	cmd.Flags().StringArrayVarP(&opts.params, "param", "p", []string{}, "Param as name=value (array values separated by commas, object values as key:value separated by commas)")
+	cmd.Flags().StringArrayVarP(&opts.workspaces, "workspace", "w", []string{}, "Workspace as name=<name> with emptyDir=, config=<configmap>, secret=<secret>, claimName=<pvc> or local=<dir> (e.g. name=source,local=.)")
+	cmd.Flags().BoolVar(&opts.useParamDefaults, "use-param-defaults", false, "Use the default value of the params not set")
+	addBuildFlags(cmd, &opts.runOption)
+	return cmd
+}
+
+func start(opts *startOption, kind, name string) error {
+	if len(opts.dirs) > 1 {
+		return errors.New("multiple -d not yet supported")
+	}
+	dir := "."
+	if len(opts.dirs) == 1 {
+		dir = opts.dirs[0]
+	}
+	resources, err := readResourceFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var run interface{}
+	var workspaces []tekton.StartWorkspace
+	switch kind {
+	case "task":
+		t, err := tekton.LookupTask(resources, name)
+		if err != nil {
+			return err
+		}
+		params, err := tekton.StartParams(t.Spec.Params, opts.params, opts.useParamDefaults)
+		if err != nil {
+			return err
+		}
+		declared := []string{}
+		for _, w := range t.Spec.Workspaces {
+			declared = append(declared, w.Name)
+		}
+		if workspaces, err = tekton.StartWorkspaces(declared, opts.workspaces); err != nil {
+			return err
+		}
+		run = tekton.NewTaskRun(name, params, workspaceBindings(workspaces))
+	case "pipeline":
+		p, err := tekton.LookupPipeline(resources, name)
+		if err != nil {
+			return err
+		}
+		params, err := tekton.StartParams(p.Spec.Params, opts.params, opts.useParamDefaults)
+		if err != nil {
+			return err
+		}
+		declared := []string{}
+		for _, w := range p.Spec.Workspaces {
+			declared = append(declared, w.Name)
+		}
+		if workspaces, err = tekton.StartWorkspaces(declared, opts.workspaces); err != nil {
+			return err
+		}
+		run = tekton.NewPipelineRun(name, params, workspaceBindings(workspaces))
+	}
+
+	// Local workspaces are bound to named contexts
+	sources := []string{}
+	for _, w := range workspaces {
+		if w.Local != "" {
+			sources = append(sources, w.Binding.Name+"="+w.Local)
+		}
+	}
+	attrs := map[string]string{}
+	locals := map[string]string{}
+	if err := workspaceContexts(sources, attrs, locals); err != nil {
+		return err
+	}
+
+	// The run is written to a "temporary" main file
+	content, err := yaml.Marshal(run)
+	if err != nil {
+		return err
+	}
+	d, err := os.MkdirTemp("", "buildkit-tekton")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(d) // clean up
+	if err := os.WriteFile(filepath.Join(d, "run.yaml"), content, 0o644); err != nil {
+		return err
+	}
+	return solve(&opts.runOption, dir, d, "run.yaml", attrs, locals)
+}
+
+func workspaceBindings(workspaces []tekton.StartWorkspace) []v1.WorkspaceBinding {
+	bindings := make([]v1.WorkspaceBinding, len(workspaces))
+	for i, w := range workspaces {
+		bindings[i] = w.Binding
+	}
+	return bindings
+}
+
+// readResourceFiles reads the YAML files of a directory, recursively, to look
+// up the Task or Pipeline to start.
+func readResourceFiles(dir string) ([]tekton.ContextResource, error) {
+	resources := []tekton.ContextResource{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(p)); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, tekton.ContextResource{Path: p, Data: string(data)})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", dir)
+	}
+	return resources, nil
+}
@@ -31,7 +31,7 @@
             # In 'nix develop', we don't need a copy of the source tree
             # in the Nix store.
             src = ./.;
-            subPackages = [ "cmd/buildkit-tekton" ];
+            subPackages = [ "cmd/buildkit-tekton" "cmd/entrypoint" ];
 
             # We use vendor, no need for vendorHash
             vendorHash = null;
@@ -55,6 +55,11 @@
               name = buildkit-tekton.pname;
               tag = buildkit-tekton.version;
               contents = [ buildkit-tekton ];
+              # The entrypoint binary is mounted from the frontend image in each step
+              extraCommands = ''
+                mkdir -p tekton/bin
+                cp ${buildkit-tekton}/bin/entrypoint tekton/bin/entrypoint
+              '';
 
               config = {
                 Cmd = [ "/bin/buildkit-tekton" ];
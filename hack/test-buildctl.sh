@@ -42,6 +42,7 @@ for f in "${td}"/examples/*; do
                          --local dockerfile=. --local context=. \
                          --opt filename=$(basename ${sf}) \
                          --opt enable-tekton-oci-bundles=true \
+                         --allow network.host \
                          --output type=image,name=${name}
             fi
         done
@@ -41,10 +41,18 @@ for f in "${td}"/examples/*; do
 			        echo "#syntax=${image}"
 			        cat "${sf}"
 		        ) | sponge "${sf}"
-		        "$DOCKER" build \
-                          --build-arg=enable-tekton-oci-bundles=true \
-                          -t "${name}" \
-                          -f "${sf}" .
+		        if [[ ${name} == *"sidecar"* ]]; then
+		            # Sidecars and the steps talking to them share the host network
+		            "$DOCKER" buildx build \
+                              --allow network.host \
+                              -t "${name}" \
+                              -f "${sf}" .
+		        else
+		            "$DOCKER" build \
+                              --build-arg=enable-tekton-oci-bundles=true \
+                              -t "${name}" \
+                              -f "${sf}" .
+		        fi
             fi
         done
 	)
@@ -1,10 +1,14 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path"
 
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/pkg/errors"
 	"github.com/vdemeester/buildkit-tekton/pkg/config"
 	"github.com/vdemeester/buildkit-tekton/pkg/tekton"
@@ -14,6 +18,10 @@ const (
 	localNameDockerfile = "dockerfile" // This is there to make it work with docker build -f …
 	keyFilename         = "filename"
 	defaultTaskName     = "task.yaml"
+	// tektonIgnoreFilename is the file listing the context files to ignore
+	tektonIgnoreFilename = ".tektonignore"
+	// sourceLanguage is the language of the source maps of the loaded files
+	sourceLanguage = "YAML"
 )
 
 // Build is the "core" of the frontend.
@@ -35,8 +43,16 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "getting context resource")
 	}
-	st, err := tekton.TektonToLLB(c)(ctx, resource, contextResources)
+	ctx, err = applyTektonConfig(ctx, c, resource, contextResources)
 	if err != nil {
+		return nil, errors.Wrap(err, "applying tekton configuration")
+	}
+	st, sidecars, err := tekton.TektonToLLB(c)(ctx, resource, contextResources)
+	if err != nil {
+		return nil, err
+	}
+	// Tasks with sidecars are solved first, each while its sidecars run
+	if err := tekton.SolveWithSidecars(ctx, c, sidecars); err != nil {
 		return nil, err
 	}
 
@@ -44,8 +60,10 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to marshal local source")
 	}
+	// The steps are run (evaluated) here, not once the result is exported
 	res, err := c.Solve(ctx, client.SolveRequest{
 		Definition: def.ToPB(),
+		Evaluate:   true,
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to resolve dockerfile")
@@ -60,14 +78,10 @@ func Build(ctx context.Context, c client.Client) (*client.Result, error) {
 	return res, nil
 }
 
-// GetTektonResource reads the specified tekton resource (TaskRun, Pipeline today), and
-// returns it as a string.
-func GetTektonResource(ctx context.Context, c client.Client) (string, error) {
-	opts := c.BuildOpts().Opts
-	filename := opts[keyFilename]
-	if filename == "" {
-		filename = defaultTaskName
-	}
+// GetTektonResource reads the main file (with the TaskRuns or PipelineRuns to
+// execute), with its source map.
+func GetTektonResource(ctx context.Context, c client.Client) (tekton.ContextResource, error) {
+	filename := mainFilename(c)
 
 	name := "load resource(s)"
 	if filename != "task.yaml" {
@@ -81,7 +95,7 @@ func GetTektonResource(ctx context.Context, c client.Client) (string, error) {
 
 	def, err := src.Marshal(ctx)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to marshal local source")
+		return tekton.ContextResource{}, errors.Wrapf(err, "failed to marshal local source")
 	}
 
 	var dtDockerfile []byte
@@ -89,61 +103,157 @@ func GetTektonResource(ctx context.Context, c client.Client) (string, error) {
 		Definition: def.ToPB(),
 	})
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to resolve tekton.yaml")
+		return tekton.ContextResource{}, errors.Wrapf(err, "failed to resolve tekton.yaml")
 	}
 
 	ref, err := res.SingleRef()
 	if err != nil {
-		return "", err
+		return tekton.ContextResource{}, err
 	}
 
 	dtDockerfile, err = ref.ReadFile(ctx, client.ReadRequest{
 		Filename: filename,
 	})
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to read tekton yaml")
+		return tekton.ContextResource{}, errors.Wrapf(err, "failed to read tekton yaml")
 	}
 
-	return string(dtDockerfile), nil
+	return tekton.ContextResource{
+		Path:      filename,
+		Data:      string(dtDockerfile),
+		SourceMap: llb.NewSourceMap(&src, filename, sourceLanguage, dtDockerfile),
+	}, nil
+}
+
+// mainFilename returns the name of the main file, in the dockerfile local.
+func mainFilename(c client.Client) string {
+	if filename := c.BuildOpts().Opts[keyFilename]; filename != "" {
+		return filename
+	}
+	return defaultTaskName
 }
 
-// GetContextResources reads all yamls from the context and returns it
-// as an array of string.
-func GetContextResources(ctx context.Context, c client.Client) ([]string, error) {
-	resources := []string{}
+// GetContextResources reads all the yamls from the context, recursively, and
+// returns them with their path. Files are selected with the context-include
+// and context-exclude options, and the .tektonignore file of the context.
+func GetContextResources(ctx context.Context, c client.Client) ([]tekton.ContextResource, error) {
+	cfg := config.FromContext(ctx)
+	ignored, err := readTektonIgnore(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 	buildContext := llb.Local("context",
-		llb.IncludePatterns([]string{"*.yml", "*.yaml"}),
+		llb.IncludePatterns(cfg.ContextInclude),
+		llb.ExcludePatterns(append(ignored, cfg.ContextExclude...)),
 		llb.SessionID(c.BuildOpts().SessionID),
 		llb.WithCustomName("[tekton] load yaml files from context"),
 	)
-	def, err := buildContext.Marshal(ctx)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load context files")
-	}
-	res, err := c.Solve(ctx, client.SolveRequest{
-		Definition: def.ToPB(),
-	})
+	ref, err := solveLocal(ctx, c, buildContext)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load context files")
 	}
+	return readContextResources(ctx, ref, &buildContext, "")
+}
 
-	ref, err := res.SingleRef()
+// readContextResources reads all the files of the given directory of the
+// context (loaded with st), recursively.
+func readContextResources(ctx context.Context, ref client.Reference, st *llb.State, dir string) ([]tekton.ContextResource, error) {
+	resources := []tekton.ContextResource{}
+	entries, err := ref.ReadDir(ctx, client.ReadDirRequest{Path: dir})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to load context files")
+		return nil, errors.Wrapf(err, "failed to list %q from context files", dir)
 	}
-
-	dirs, err := ref.ReadDir(ctx, client.ReadDirRequest{Path: ""})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load context files")
-	}
-	for _, d := range dirs {
+	for _, e := range entries {
+		p := path.Join(dir, e.Path)
+		if os.FileMode(e.Mode).IsDir() {
+			sub, err := readContextResources(ctx, ref, st, p)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, sub...)
+			continue
+		}
 		data, err := ref.ReadFile(ctx, client.ReadRequest{
-			Filename: d.Path,
+			Filename: p,
 		})
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read %s from context files", d.Path)
+			return nil, errors.Wrapf(err, "failed to read %s from context files", p)
 		}
-		resources = append(resources, string(data))
+		resources = append(resources, tekton.ContextResource{
+			Path:      p,
+			Data:      string(data),
+			SourceMap: llb.NewSourceMap(st, p, sourceLanguage, data),
+		})
 	}
 	return resources, nil
 }
+
+// readTektonIgnore reads the exclude patterns of the .tektonignore file of the
+// context, if any.
+func readTektonIgnore(ctx context.Context, c client.Client) ([]string, error) {
+	data, err := readContextFile(ctx, c, tektonIgnoreFilename)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	patterns, err := ignorefile.ReadAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", tektonIgnoreFilename)
+	}
+	return patterns, nil
+}
+
+// applyTektonConfig applies the Tekton feature-flags and config-defaults
+// ConfigMaps of the tekton-config file or, without it, the ones of the
+// tekton-pipelines namespace found in the main file and the context files.
+func applyTektonConfig(ctx context.Context, c client.Client, resource tekton.ContextResource, contextResources []tekton.ContextResource) (context.Context, error) {
+	filename := config.FromContext(ctx).TektonConfig
+	if filename == "" {
+		resources := append([]tekton.ContextResource{resource}, contextResources...)
+		return tekton.WithTektonConfig(ctx, resources, tekton.TektonNamespace)
+	}
+	data, err := readContextFile(ctx, c, filename)
+	if err != nil {
+		return ctx, err
+	}
+	if data == nil {
+		return ctx, errors.Errorf("tekton-config %s not found in context", filename)
+	}
+	return tekton.WithTektonConfig(ctx, []tekton.ContextResource{{Path: filename, Data: string(data)}}, "")
+}
+
+// readContextFile reads a single file of the context, nil if it does not
+// exist.
+func readContextFile(ctx context.Context, c client.Client, filename string) ([]byte, error) {
+	src := llb.Local("context",
+		llb.IncludePatterns([]string{filename}),
+		llb.SessionID(c.BuildOpts().SessionID),
+		llb.SharedKeyHint(filename),
+		llb.WithCustomName("[tekton] load "+filename),
+	)
+	ref, err := solveLocal(ctx, c, src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load %s", filename)
+	}
+	data, err := ref.ReadFile(ctx, client.ReadRequest{
+		Filename: filename,
+	})
+	if err != nil {
+		// No such file in the context
+		return nil, nil
+	}
+	return data, nil
+}
+
+func solveLocal(ctx context.Context, c client.Client, st llb.State) (client.Reference, error) {
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Solve(ctx, client.SolveRequest{
+		Definition: def.ToPB(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.SingleRef()
+}
@@ -159,6 +159,10 @@ func installBuildkit(ctx context.Context, command, containerName string) error {
 		"--name", containerName,
 		"--privileged", // TODO: try to remove privileged at some point
 		"docker.io/moby/buildkit:"+vendoredVersion,
+		// Sidecars need the steps to share the host network
+		"--allow-insecure-entitlement", "network.host",
+		// Privileged steps and sidecars run in insecure mode
+		"--allow-insecure-entitlement", "security.insecure",
 	)
 	output, err = cmd.CombinedOutput()
 	if err != nil {
@@ -2,22 +2,117 @@ package config
 
 import (
 	"context"
+	"strconv"
 	"strings"
 
+	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
 	"github.com/tektoncd/pipeline/pkg/apis/config"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// Version is the version of the frontend, set at build time with
+// -ldflags "-X github.com/vdemeester/buildkit-tekton/pkg/config.Version=<version>".
+var Version = "latest"
+
+// frontendRepository is the repository of the frontend image, which ships the
+// entrypoint binary.
+const frontendRepository = "ghcr.io/vdemeester/buildkit-tekton/frontend"
+
+// keySource is the frontend option with the image the frontend runs from, set
+// by BuildKit for gateway frontends (e.g. with #syntax).
+const keySource = "source"
+
+// DefaultEntrypointImage returns the image the entrypoint binary is taken from
+// (/tekton/bin/entrypoint) when the frontend image is unknown (e.g. with
+// tkn-local): the frontend image of the same version, as the entrypoint flags
+// change with the frontend.
+func DefaultEntrypointImage() string {
+	return frontendRepository + ":" + Version
+}
+
+// DefaultContextInclude are the files of the context loaded by default, in
+// any directory.
+var DefaultContextInclude = []string{"**/*.yaml", "**/*.yml"}
+
+// AllRuns is the run option value selecting all the runs of the main file.
+const AllRuns = "*"
+
+type configKey struct{}
+
 // Config holds the frontend configuration options.
 // It "brings" some from upstream tekton own set of configuration.
 type Config struct {
 	Defaults     config.Defaults
 	FeatureFlags config.FeatureFlags
+	// CoscheduleSet is whether the feature-flags ConfigMap sets coschedule
+	// (instead of using the Tekton default).
+	CoscheduleSet bool
+
+	// EntrypointImage is the image containing the entrypoint binary mounted in each step
+	EntrypointImage string
+	// CgroupParent is the cgroup hierarchy the steps of each Task run under
+	// (in a per-Task child), where cpu and memory limits are set by the
+	// operator. Empty means the BuildKit worker default.
+	CgroupParent string
+	// Ulimits are the ulimits (e.g. nofile, nproc) of every step.
+	Ulimits []Ulimit
+	// ContextInclude and ContextExclude are the patterns (like in a
+	// .dockerignore file) of the context files to load resources from.
+	ContextInclude []string
+	ContextExclude []string
+	// Run selects the run (TaskRun or PipelineRun, by name or generateName)
+	// to execute when the main file has several, AllRuns to execute all of
+	// them concurrently.
+	Run string
+	// Params are the param values overriding the ones of the run, by param
+	// name (param:<name>=<value>).
+	Params map[string]string
+	// Workspaces are the workspace bindings overriding the ones of the run,
+	// by workspace name (workspace:<name>=<binding>).
+	Workspaces map[string]string
+	// TektonConfig is the file (in the context) of the feature-flags and
+	// config-defaults ConfigMaps. Empty means they are looked up in the
+	// context.
+	TektonConfig string
+
+	// enableAPIFields is the enable-api-fields option, which takes
+	// precedence over the feature-flags ConfigMap.
+	enableAPIFields string
+}
+
+// Ulimit is a ulimit applied to the steps.
+type Ulimit struct {
+	Name llb.UlimitName
+	Soft int64
+	Hard int64
+}
+
+// newConfig returns the default configuration, with the Tekton defaults (as
+// without feature-flags and config-defaults ConfigMaps).
+func newConfig() *Config {
+	c := &Config{
+		EntrypointImage: DefaultEntrypointImage(),
+		ContextInclude:  DefaultContextInclude,
+	}
+	if defaults, err := config.NewDefaultsFromMap(map[string]string{}); err == nil {
+		c.Defaults = *defaults
+	}
+	if featureFlags, err := config.NewFeatureFlagsFromMap(map[string]string{}); err == nil {
+		c.FeatureFlags = *featureFlags
+	}
+	return c
 }
 
 // Parse converts BuildKit BuildOpts into a Config object
 func Parse(opts client.BuildOpts) (*Config, error) {
-	c := &Config{}
+	c := newConfig()
+	// The entrypoint binary is taken from the frontend image itself, unless
+	// the entrypoint-image option is set.
+	if source := opts.Opts[keySource]; source != "" {
+		c.EntrypointImage = source
+	}
 
 	for name, value := range opts.Opts {
 		// we use --build-arg to pass option through "docker build"
@@ -25,23 +120,158 @@ func Parse(opts client.BuildOpts) (*Config, error) {
 		if strings.HasPrefix(name, "build-arg:") {
 			name = strings.TrimPrefix(name, "build-arg:")
 		}
+		if param := strings.TrimPrefix(name, "param:"); param != name {
+			if c.Params == nil {
+				c.Params = map[string]string{}
+			}
+			c.Params[param] = value
+			continue
+		}
+		if workspace := strings.TrimPrefix(name, "workspace:"); workspace != name {
+			if c.Workspaces == nil {
+				c.Workspaces = map[string]string{}
+			}
+			c.Workspaces[workspace] = value
+			continue
+		}
 		// TODO: Support more options
 		switch name {
 		case "enable-api-fields":
 			c.FeatureFlags.EnableAPIFields = value
+			c.enableAPIFields = value
+		case "tekton-config":
+			c.TektonConfig = value
 		case "enable-tekton-oci-bundles":
 			// OCI bundles are now handled via resolvers, this option is deprecated
 			_ = value
+		case "entrypoint-image":
+			c.EntrypointImage = value
+		case "run":
+			c.Run = value
+		case "cgroup-parent":
+			c.CgroupParent = value
+		case "context-include":
+			c.ContextInclude = splitPatterns(value)
+		case "context-exclude":
+			c.ContextExclude = splitPatterns(value)
+		case "ulimit":
+			ulimits, err := parseUlimits(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ulimit option %q", value)
+			}
+			c.Ulimits = ulimits
 		}
 	}
 
 	return c, nil
 }
 
-// ToContext enriches a context with Tekton configuration object
+// ToContext enriches a context with Tekton configuration object, and the
+// frontend configuration itself.
 func (c *Config) ToContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, configKey{}, c)
 	return config.ToContext(ctx, &config.Config{
 		Defaults:     &c.Defaults,
 		FeatureFlags: &c.FeatureFlags,
 	})
 }
+
+// FromContext returns the frontend configuration from the context, or the
+// default one if there is none.
+func FromContext(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey{}).(*Config); ok && c != nil {
+		return c
+	}
+	return newConfig()
+}
+
+// ApplyConfigMap applies a Tekton feature-flags or config-defaults ConfigMap
+// to the configuration, the enable-api-fields option taking precedence. It
+// returns false for any other ConfigMap.
+func (c *Config) ApplyConfigMap(cm *corev1.ConfigMap) (bool, error) {
+	switch cm.Name {
+	case config.GetFeatureFlagsConfigName():
+		featureFlags, err := config.NewFeatureFlagsFromConfigMap(cm)
+		if err != nil {
+			return true, errors.Wrapf(err, "invalid %s ConfigMap", cm.Name)
+		}
+		if c.enableAPIFields != "" {
+			featureFlags.EnableAPIFields = c.enableAPIFields
+		}
+		c.FeatureFlags = *featureFlags
+		_, c.CoscheduleSet = cm.Data["coschedule"]
+		return true, nil
+	case config.GetDefaultsConfigName():
+		defaults, err := config.NewDefaultsFromConfigMap(cm)
+		if err != nil {
+			return true, errors.Wrapf(err, "invalid %s ConfigMap", cm.Name)
+		}
+		c.Defaults = *defaults
+		return true, nil
+	}
+	return false, nil
+}
+
+// splitPatterns splits a comma-separated list of patterns.
+func splitPatterns(value string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parseUlimits parses a comma-separated list of ulimits, as <name>=<soft>[:<hard>]
+// (e.g. nofile=1024:4096,nproc=512), the hard limit defaulting to the soft one.
+func parseUlimits(value string) ([]Ulimit, error) {
+	ulimits := []Ulimit{}
+	for _, field := range strings.Split(value, ",") {
+		if field == "" {
+			continue
+		}
+		name, limits, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, errors.Errorf("%s: expected <name>=<soft>[:<hard>]", field)
+		}
+		if !knownUlimits[llb.UlimitName(name)] {
+			return nil, errors.Errorf("%s: unknown ulimit %s", field, name)
+		}
+		softValue, hardValue, hasHard := strings.Cut(limits, ":")
+		soft, err := strconv.ParseInt(softValue, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid soft limit", field)
+		}
+		hard := soft
+		if hasHard {
+			hard, err = strconv.ParseInt(hardValue, 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: invalid hard limit", field)
+			}
+		}
+		if soft > hard {
+			return nil, errors.Errorf("%s: soft limit is greater than the hard limit", field)
+		}
+		ulimits = append(ulimits, Ulimit{Name: llb.UlimitName(name), Soft: soft, Hard: hard})
+	}
+	return ulimits, nil
+}
+
+var knownUlimits = map[llb.UlimitName]bool{
+	llb.UlimitCore:       true,
+	llb.UlimitCPU:        true,
+	llb.UlimitData:       true,
+	llb.UlimitFsize:      true,
+	llb.UlimitLocks:      true,
+	llb.UlimitMemlock:    true,
+	llb.UlimitMsgqueue:   true,
+	llb.UlimitNice:       true,
+	llb.UlimitNofile:     true,
+	llb.UlimitNproc:      true,
+	llb.UlimitRss:        true,
+	llb.UlimitRtprio:     true,
+	llb.UlimitRttime:     true,
+	llb.UlimitSigpending: true,
+	llb.UlimitStack:      true,
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/tektoncd/pipeline/pkg/apis/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseUlimits(t *testing.T) {
+	got, err := parseUlimits("nofile=1024:4096,nproc=512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Ulimit{
+		{Name: llb.UlimitNofile, Soft: 1024, Hard: 4096},
+		{Name: llb.UlimitNproc, Soft: 512, Hard: 512},
+	}
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Errorf("unexpected ulimits: %s", d)
+	}
+	for _, invalid := range []string{"nofile", "unknown=1", "nofile=a", "nofile=1:b", "nofile=10:5"} {
+		if _, err := parseUlimits(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseContextPatterns(t *testing.T) {
+	c, err := Parse(client.BuildOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(DefaultContextInclude, c.ContextInclude); d != "" {
+		t.Errorf("unexpected default include patterns: %s", d)
+	}
+	c, err = Parse(client.BuildOpts{Opts: map[string]string{
+		"context-include":           "tekton/**/*.yaml, *.yml",
+		"build-arg:context-exclude": "vendor,,charts/**",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"tekton/**/*.yaml", "*.yml"}, c.ContextInclude); d != "" {
+		t.Errorf("unexpected include patterns: %s", d)
+	}
+	if d := cmp.Diff([]string{"vendor", "charts/**"}, c.ContextExclude); d != "" {
+		t.Errorf("unexpected exclude patterns: %s", d)
+	}
+}
+
+func TestParseOverrides(t *testing.T) {
+	c, err := Parse(client.BuildOpts{Opts: map[string]string{
+		"param:version":             "1.0",
+		"build-arg:param:flags":     "-v,-race",
+		"workspace:source":          "context:src",
+		"build-arg:workspace:cache": "emptyDir",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(map[string]string{"version": "1.0", "flags": "-v,-race"}, c.Params); d != "" {
+		t.Errorf("unexpected params: %s", d)
+	}
+	if d := cmp.Diff(map[string]string{"source": "context:src", "cache": "emptyDir"}, c.Workspaces); d != "" {
+		t.Errorf("unexpected workspaces: %s", d)
+	}
+}
+
+func TestParseEntrypointImage(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     map[string]string
+		expected string
+	}{{
+		name:     "default",
+		expected: DefaultEntrypointImage(),
+	}, {
+		name:     "frontend image",
+		opts:     map[string]string{"source": "registry.example.com/tekton/frontend:v1.2.3"},
+		expected: "registry.example.com/tekton/frontend:v1.2.3",
+	}, {
+		name:     "option",
+		opts:     map[string]string{"source": "registry.example.com/tekton/frontend:v1.2.3", "build-arg:entrypoint-image": "entrypoint:dev"},
+		expected: "entrypoint:dev",
+	}, {
+		name:     "build-arg is not the frontend image",
+		opts:     map[string]string{"build-arg:source": "src"},
+		expected: DefaultEntrypointImage(),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := Parse(client.BuildOpts{Opts: tc.opts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.EntrypointImage != tc.expected {
+				t.Errorf("expected entrypoint image %q, got %q", tc.expected, c.EntrypointImage)
+			}
+		})
+	}
+}
+
+func TestApplyConfigMap(t *testing.T) {
+	c, err := Parse(client.BuildOpts{Opts: map[string]string{"enable-api-fields": "alpha"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Defaults.DefaultTimeoutMinutes != config.DefaultTimeoutMinutes || c.FeatureFlags.MaxResultSize != config.DefaultMaxResultSize {
+		t.Errorf("expected the Tekton defaults, got %+v and %+v", c.Defaults, c.FeatureFlags)
+	}
+	for _, cm := range []*corev1.ConfigMap{{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature-flags"},
+		Data:       map[string]string{"enable-api-fields": "beta", "results-from": "sidecar-logs", "max-result-size": "8192"},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "config-defaults"},
+		Data:       map[string]string{"default-timeout-minutes": "5", "default-service-account": "builder"},
+	}} {
+		ok, err := c.ApplyConfigMap(cm)
+		if err != nil || !ok {
+			t.Fatalf("expected %s to be applied, got %v, %v", cm.Name, ok, err)
+		}
+	}
+	// The enable-api-fields option takes precedence
+	if c.FeatureFlags.EnableAPIFields != "alpha" {
+		t.Errorf("expected enable-api-fields alpha, got %s", c.FeatureFlags.EnableAPIFields)
+	}
+	if c.FeatureFlags.ResultExtractionMethod != config.ResultExtractionMethodSidecarLogs || c.FeatureFlags.MaxResultSize != 8192 {
+		t.Errorf("unexpected feature flags: %+v", c.FeatureFlags)
+	}
+	if c.Defaults.DefaultTimeoutMinutes != 5 || c.Defaults.DefaultServiceAccount != "builder" {
+		t.Errorf("unexpected defaults: %+v", c.Defaults)
+	}
+	if c.CoscheduleSet {
+		t.Errorf("expected coschedule not to be set")
+	}
+	if _, err := c.ApplyConfigMap(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature-flags"},
+		Data:       map[string]string{"coschedule": "workspaces"},
+	}); err != nil || !c.CoscheduleSet {
+		t.Errorf("expected coschedule to be set, got %v, %v", c.CoscheduleSet, err)
+	}
+
+	if ok, err := c.ApplyConfigMap(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings"}}); ok || err != nil {
+		t.Errorf("expected an unrelated ConfigMap to be ignored, got %v, %v", ok, err)
+	}
+	if _, err := c.ApplyConfigMap(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature-flags"},
+		Data:       map[string]string{"results-from": "unknown"},
+	}); err == nil {
+		t.Errorf("expected an error for an invalid feature-flags ConfigMap")
+	}
+}
@@ -0,0 +1,141 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const dockerHubRegistryHost = "registry-1.docker.io"
+
+type contextKey struct{}
+
+// Store holds registry credentials discovered while translating Tekton
+// resources (e.g. imagePullSecrets). It is meant to be shared between the
+// frontend and the client session auth provider.
+type Store struct {
+	mu    sync.RWMutex
+	auths map[string]types.AuthConfig
+	// sources are the names of the secrets the credentials come from
+	sources map[string]string
+}
+
+// NewStore returns an empty credentials Store.
+func NewStore() *Store {
+	return &Store{
+		auths:   map[string]types.AuthConfig{},
+		sources: map[string]string{},
+	}
+}
+
+// ToContext enriches a context with a credentials Store.
+func ToContext(ctx context.Context, s *Store) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the credentials Store from the context, or nil if
+// there is none (e.g. when running as a frontend image).
+func FromContext(ctx context.Context) *Store {
+	s, _ := ctx.Value(contextKey{}).(*Store)
+	return s
+}
+
+// AddSecret loads the registry credentials from a kubernetes.io/dockerconfigjson
+// (or legacy kubernetes.io/dockercfg) secret into the Store. The credentials
+// of a registry are used for the whole build (e.g. by all the Tasks of a
+// Pipeline), so a secret with other credentials for a registry than the ones
+// already loaded is rejected.
+func (s *Store) AddSecret(secret *corev1.Secret) error {
+	var data []byte
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		data = secret.Data[corev1.DockerConfigJsonKey]
+		if v, ok := secret.StringData[corev1.DockerConfigJsonKey]; ok {
+			data = []byte(v)
+		}
+	case corev1.SecretTypeDockercfg:
+		dockercfg := secret.Data[corev1.DockerConfigKey]
+		if v, ok := secret.StringData[corev1.DockerConfigKey]; ok {
+			dockercfg = []byte(v)
+		}
+		data = append(append([]byte(`{"auths":`), dockercfg...), '}')
+	default:
+		return errors.Errorf("secret %s is of type %q, expected %q", secret.Name, secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	cf := configfile.New("")
+	if err := cf.LoadFromReader(bytes.NewReader(data)); err != nil {
+		return errors.Wrapf(err, "failed to parse docker config from secret %s", secret.Name)
+	}
+	auths := cf.GetAuthConfigs()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for addr, ac := range auths {
+		host := normalizeHost(addr)
+		if existing, ok := s.auths[host]; ok && !sameCredentials(existing, ac) {
+			return errors.Errorf("secret %s has other credentials for %s than secret %s, only one set of credentials per registry is supported", secret.Name, host, s.sources[host])
+		}
+	}
+	for addr, ac := range auths {
+		host := normalizeHost(addr)
+		if _, ok := s.auths[host]; !ok {
+			s.auths[host] = ac
+			s.sources[host] = secret.Name
+		}
+	}
+	return nil
+}
+
+// sameCredentials returns whether a and b authenticate the same way, whatever
+// the form of their registry address.
+func sameCredentials(a, b types.AuthConfig) bool {
+	a.ServerAddress, b.ServerAddress = "", ""
+	return a == b
+}
+
+// Get returns the credentials known for the given registry host.
+func (s *Store) Get(host string) (types.AuthConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ac, ok := s.auths[normalizeHost(host)]
+	return ac, ok
+}
+
+// AuthConfigProvider returns an authprovider.AuthConfigProvider that looks up
+// credentials in the Store first, and falls back to the given provider
+// (usually the docker configuration file).
+func (s *Store) AuthConfigProvider(fallback authprovider.AuthConfigProvider) authprovider.AuthConfigProvider {
+	return func(ctx context.Context, host string, scopes []string, cacheCheck authprovider.ExpireCachedAuthCheck) (types.AuthConfig, error) {
+		if ac, ok := s.Get(host); ok {
+			return ac, nil
+		}
+		if fallback == nil {
+			return types.AuthConfig{}, nil
+		}
+		return fallback(ctx, host, scopes, cacheCheck)
+	}
+}
+
+// normalizeHost converts a docker config "auths" key (which can be a
+// hostname or a URL) to a registry host as requested by BuildKit.
+func normalizeHost(addr string) string {
+	host := addr
+	if strings.Contains(addr, "://") {
+		if u, err := url.Parse(addr); err == nil {
+			host = u.Host
+		}
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "docker.io", "index.docker.io":
+		return dockerHubRegistryHost
+	}
+	return host
+}
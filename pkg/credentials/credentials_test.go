@@ -0,0 +1,143 @@
+package credentials_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/cli/cli/config/types"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/vdemeester/buildkit-tekton/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStoreAddSecret(t *testing.T) {
+	tests := []struct {
+		name         string
+		secret       *corev1.Secret
+		host         string
+		expectedUser string
+	}{{
+		name: "dockerconfigjson",
+		secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				// foo:bar
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"Zm9vOmJhcg=="}}}`),
+			},
+		},
+		host:         "quay.io",
+		expectedUser: "foo",
+	}, {
+		name: "dockerconfigjson-docker-hub",
+		secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			StringData: map[string]string{
+				corev1.DockerConfigJsonKey: `{"auths":{"https://index.docker.io/v1/":{"username":"foo","password":"bar"}}}`,
+			},
+		},
+		host:         "registry-1.docker.io",
+		expectedUser: "foo",
+	}, {
+		name: "dockercfg",
+		secret: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "regcred"},
+			Type:       corev1.SecretTypeDockercfg,
+			Data: map[string][]byte{
+				corev1.DockerConfigKey: []byte(`{"https://registry.example.com":{"username":"foo","password":"bar"}}`),
+			},
+		},
+		host:         "registry.example.com",
+		expectedUser: "foo",
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := credentials.NewStore()
+			if err := s.AddSecret(tc.secret); err != nil {
+				t.Fatal(err)
+			}
+			ac, ok := s.Get(tc.host)
+			if !ok {
+				t.Fatalf("expected credentials for %s", tc.host)
+			}
+			if ac.Username != tc.expectedUser || ac.Password != "bar" {
+				t.Errorf("unexpected credentials for %s: %+v", tc.host, ac)
+			}
+		})
+	}
+}
+
+func TestStoreAddSecretInvalidType(t *testing.T) {
+	s := credentials.NewStore()
+	err := s.AddSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opaque"},
+		Type:       corev1.SecretTypeOpaque,
+	})
+	if err == nil {
+		t.Fatalf("expected an error, got nothing")
+	}
+}
+
+func TestStoreAddSecretConflict(t *testing.T) {
+	secret := func(name, config string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+		}
+	}
+	s := credentials.NewStore()
+	if err := s.AddSecret(secret("regcred", `{"auths":{"https://index.docker.io/v1/":{"username":"foo","password":"bar"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	// The same credentials (e.g. from another Task) are fine
+	if err := s.AddSecret(secret("same", `{"auths":{"docker.io":{"username":"foo","password":"bar"}}}`)); err != nil {
+		t.Errorf("expected the same credentials to be accepted, got %v", err)
+	}
+	// Other credentials for the same registry would apply to all the Tasks
+	err := s.AddSecret(secret("other", `{"auths":{"docker.io":{"username":"baz","password":"qux"},"quay.io":{"username":"baz","password":"qux"}}}`))
+	if err == nil {
+		t.Fatal("expected an error for conflicting credentials")
+	}
+	if _, ok := s.Get("quay.io"); ok {
+		t.Errorf("expected the conflicting secret not to be loaded")
+	}
+	if ac, _ := s.Get("docker.io"); ac.Username != "foo" {
+		t.Errorf("expected the first credentials to be kept, got %+v", ac)
+	}
+}
+
+func TestStoreAuthConfigProvider(t *testing.T) {
+	s := credentials.NewStore()
+	if err := s.AddSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "regcred"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"username":"foo","password":"bar"}}}`),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fallback := func(context.Context, string, []string, authprovider.ExpireCachedAuthCheck) (types.AuthConfig, error) {
+		return types.AuthConfig{Username: "fallback"}, nil
+	}
+	provider := s.AuthConfigProvider(fallback)
+
+	ac, err := provider(context.Background(), "quay.io", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Username != "foo" {
+		t.Errorf("expected credentials from the store, got %+v", ac)
+	}
+	ac, err = provider(context.Background(), "ghcr.io", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Username != "fallback" {
+		t.Errorf("expected credentials from the fallback, got %+v", ac)
+	}
+}
@@ -0,0 +1,68 @@
+package tekton
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+)
+
+// v1beta1AnnotationPrefix is the prefix of the annotations v1beta1 fields
+// without v1 equivalent are serialized to on conversion.
+const v1beta1AnnotationPrefix = "tekton.dev/v1beta1"
+
+// convertToV1 converts v1beta1 Tekton resources to v1 (and v1alpha1
+// StepActions to v1beta1, StepActions having no v1 version), using Tekton own
+// conversions. Other objects are returned as is.
+func convertToV1(ctx context.Context, obj runtime.Object) (runtime.Object, error) {
+	var source, sink interface {
+		apis.Convertible
+		metav1.Object
+	}
+	switch o := obj.(type) {
+	case *v1beta1.Task:
+		source, sink = o, &v1.Task{}
+	case *v1beta1.TaskRun:
+		source, sink = o, &v1.TaskRun{}
+	case *v1beta1.Pipeline:
+		source, sink = o, &v1.Pipeline{}
+	case *v1beta1.PipelineRun:
+		source, sink = o, &v1.PipelineRun{}
+	case *v1alpha1.StepAction:
+		source, sink = o, &v1beta1.StepAction{}
+	default:
+		return obj, nil
+	}
+	kind := obj.GetObjectKind().GroupVersionKind()
+	logrus.Infof("Converting %s %s %s", kind.GroupVersion(), kind.Kind, source.GetName())
+	if err := source.ConvertTo(ctx, sink); err != nil {
+		return nil, errors.Wrapf(err, "failed to convert %s %s %s", kind.GroupVersion(), kind.Kind, source.GetName())
+	}
+	// Deprecated fields are reported by warnDeprecations, with their location
+	return sink.(runtime.Object), nil
+}
+
+// warnDeprecations warns about the deprecated fields dropped by a conversion,
+// which are serialized into the annotations of the converted resource (what).
+// Warnings are reported at loc, the location of the resource.
+func warnDeprecations(ctx context.Context, c client.Client, loc location, what string, annotations map[string]string) {
+	keys := []string{}
+	for k := range annotations {
+		if strings.HasPrefix(k, v1beta1AnnotationPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		warn(ctx, c, loc, "%s: deprecated v1beta1 fields are ignored (%s): %s", what, k, annotations[k])
+	}
+}
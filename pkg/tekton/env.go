@@ -0,0 +1,348 @@
+package tekton
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// syntheticNodeName and syntheticPodIP are used to resolve fieldRef
+	// referring to the node or the pod network, as there is none.
+	syntheticNodeName = "buildkit"
+	syntheticPodIP    = "127.0.0.1"
+)
+
+// podMetadata is the synthetic metadata of the pod a Task would run in, used
+// to resolve env fieldRef, and the PodTemplate of its run.
+type podMetadata struct {
+	name               string
+	namespace          string
+	uid                string
+	labels             map[string]string
+	annotations        map[string]string
+	serviceAccountName string
+	template           *pod.Template
+}
+
+// taskRunPodMetadata returns the metadata of the pod running the given TaskRun.
+func taskRunPodMetadata(tr *v1.TaskRun, taskName string) podMetadata {
+	labels := copyMap(tr.Labels)
+	labels[pipeline.TaskRunLabelKey] = tr.Name
+	if tr.Spec.TaskRef != nil && tr.Spec.TaskRef.Name != "" {
+		labels[pipeline.TaskLabelKey] = taskName
+	}
+	return podMetadata{
+		name:               tr.Name + "-pod",
+		namespace:          namespaceOrDefault(tr.Namespace),
+		uid:                string(tr.UID),
+		labels:             labels,
+		annotations:        copyMap(tr.Annotations),
+		serviceAccountName: tr.Spec.ServiceAccountName,
+		template:           tr.Spec.PodTemplate,
+	}
+}
+
+// pipelineTaskPodMetadata returns the metadata of the pod running the given
+// PipelineTask, as part of the given PipelineRun (with its TaskRunSpecs).
+func pipelineTaskPodMetadata(pr *v1.PipelineRun, pt v1.PipelineTask, trs v1.PipelineTaskRunSpec) podMetadata {
+	taskRunName := pr.Name + "-" + pt.Name
+	labels := copyMap(pr.Labels)
+	annotations := copyMap(pr.Annotations)
+	if trs.Metadata != nil {
+		for k, v := range trs.Metadata.Labels {
+			labels[k] = v
+		}
+		for k, v := range trs.Metadata.Annotations {
+			annotations[k] = v
+		}
+	}
+	labels[pipeline.PipelineRunLabelKey] = pr.Name
+	labels[pipeline.PipelineTaskLabelKey] = pt.Name
+	labels[pipeline.TaskRunLabelKey] = taskRunName
+	if pt.TaskRef != nil && pt.TaskRef.Name != "" {
+		labels[pipeline.TaskLabelKey] = pt.TaskRef.Name
+	}
+	return podMetadata{
+		name:               taskRunName + "-pod",
+		namespace:          namespaceOrDefault(pr.Namespace),
+		uid:                string(pr.UID),
+		labels:             labels,
+		annotations:        annotations,
+		serviceAccountName: trs.ServiceAccountName,
+		template:           trs.PodTemplate,
+	}
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return corev1.NamespaceDefault
+	}
+	return namespace
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// envVar is a resolved environment variable.
+type envVar struct {
+	name  string
+	value string
+}
+
+// stepEnv resolves the environment of a container (envFrom, then env, with
+// valueFrom references and $(VAR) expansion), following Kubernetes semantics.
+func stepEnv(envFrom []corev1.EnvFromSource, env []corev1.EnvVar, meta podMetadata, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) ([]envVar, error) {
+	vars := []envVar{}
+	values := map[string]string{}
+	set := func(name, value string) {
+		if _, ok := values[name]; ok {
+			for i := range vars {
+				if vars[i].name == name {
+					vars[i].value = value
+				}
+			}
+		} else {
+			vars = append(vars, envVar{name: name, value: value})
+		}
+		values[name] = value
+	}
+
+	for _, from := range envFrom {
+		data, err := envFromData(from, configs, secrets)
+		if err != nil {
+			return nil, err
+		}
+		// Sort the keys so that the generated LLB is deterministic
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			name := from.Prefix + k
+			if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+				// Kubernetes skips invalid keys as well
+				continue
+			}
+			set(name, data[k])
+		}
+	}
+
+	for _, e := range env {
+		value := e.Value
+		if e.ValueFrom != nil {
+			v, found, err := envValueFrom(e, meta, configs, secrets)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			value = v
+		} else {
+			value = expandEnv(value, values)
+		}
+		set(e.Name, value)
+	}
+	return vars, nil
+}
+
+func envFromData(from corev1.EnvFromSource, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (map[string]string, error) {
+	data := map[string]string{}
+	switch {
+	case from.ConfigMapRef != nil:
+		cm, ok := configs[from.ConfigMapRef.Name]
+		if !ok || cm == nil {
+			if isOptional(from.ConfigMapRef.Optional) {
+				return data, nil
+			}
+			return nil, errors.Errorf("envFrom: configmap %s not found in context", from.ConfigMapRef.Name)
+		}
+		for k, v := range cm.Data {
+			data[k] = v
+		}
+	case from.SecretRef != nil:
+		secret, ok := secrets[from.SecretRef.Name]
+		if !ok || secret == nil {
+			if isOptional(from.SecretRef.Optional) {
+				return data, nil
+			}
+			return nil, errors.Errorf("envFrom: secret %s not found in context", from.SecretRef.Name)
+		}
+		for k, v := range files.SecretData(secret) {
+			data[k] = string(v)
+		}
+	}
+	return data, nil
+}
+
+// envValueFrom resolves an env valueFrom. It returns false if the reference
+// is optional and not found.
+func envValueFrom(e corev1.EnvVar, meta podMetadata, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (string, bool, error) {
+	from := e.ValueFrom
+	switch {
+	case from.ConfigMapKeyRef != nil:
+		ref := from.ConfigMapKeyRef
+		cm, ok := configs[ref.Name]
+		if !ok || cm == nil {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, errors.Errorf("env %s: configmap %s not found in context", e.Name, ref.Name)
+		}
+		v, ok := cm.Data[ref.Key]
+		if !ok {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, errors.Errorf("env %s: key %s not found in configmap %s", e.Name, ref.Key, ref.Name)
+		}
+		return v, true, nil
+	case from.SecretKeyRef != nil:
+		ref := from.SecretKeyRef
+		secret, ok := secrets[ref.Name]
+		if !ok || secret == nil {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, errors.Errorf("env %s: secret %s not found in context", e.Name, ref.Name)
+		}
+		v, ok := files.SecretData(secret)[ref.Key]
+		if !ok {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, errors.Errorf("env %s: key %s not found in secret %s", e.Name, ref.Key, ref.Name)
+		}
+		return string(v), true, nil
+	case from.FieldRef != nil:
+		v, err := meta.fieldValue(from.FieldRef.FieldPath)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "env %s", e.Name)
+		}
+		return v, true, nil
+	case from.ResourceFieldRef != nil:
+		return "", false, errors.Errorf("env %s: resourceFieldRef not supported", e.Name)
+	}
+	return "", false, errors.Errorf("env %s: empty valueFrom", e.Name)
+}
+
+// fieldValue returns the value of the given (downward API) field path.
+func (m podMetadata) fieldValue(path string) (string, error) {
+	switch path {
+	case "metadata.name":
+		return m.name, nil
+	case "metadata.namespace":
+		return m.namespace, nil
+	case "metadata.uid":
+		return m.uid, nil
+	case "spec.nodeName":
+		return syntheticNodeName, nil
+	case "spec.serviceAccountName":
+		return m.serviceAccountName, nil
+	case "status.hostIP", "status.hostIPs", "status.podIP", "status.podIPs":
+		return syntheticPodIP, nil
+	case "metadata.labels":
+		return formatMap(m.labels), nil
+	case "metadata.annotations":
+		return formatMap(m.annotations), nil
+	}
+	if key, ok := subscript(path, "metadata.labels"); ok {
+		return m.labels[key], nil
+	}
+	if key, ok := subscript(path, "metadata.annotations"); ok {
+		return m.annotations[key], nil
+	}
+	return "", errors.Errorf("unsupported fieldRef %s", path)
+}
+
+// subscript returns the key of a path like metadata.labels['key'].
+func subscript(path, prefix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix+"['") || !strings.HasSuffix(path, "']") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(path, prefix+"['"), "']"), true
+}
+
+// formatMap formats a map the same way the downward API does (sorted key="value" lines).
+func formatMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("%s=%q", k, m[k])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+// expandEnv expands $(VAR) references to the given variables, the same way
+// Kubernetes does for command, args and env: $$ escapes a $, and references
+// to undefined variables are left untouched.
+func expandEnv(input string, values map[string]string) string {
+	var buf strings.Builder
+	for i := 0; i < len(input); i++ {
+		if input[i] != '$' || i+1 >= len(input) {
+			buf.WriteByte(input[i])
+			continue
+		}
+		switch next := input[i+1]; next {
+		case '$':
+			// Escaped $
+			buf.WriteByte('$')
+			i++
+		case '(':
+			end := strings.IndexByte(input[i+2:], ')')
+			if end < 0 {
+				buf.WriteString(input[i:])
+				return buf.String()
+			}
+			name := input[i+2 : i+2+end]
+			if v, ok := values[name]; ok {
+				buf.WriteString(v)
+			} else {
+				buf.WriteString(input[i : i+3+end])
+			}
+			i += 2 + end
+		default:
+			buf.WriteByte('$')
+		}
+	}
+	return buf.String()
+}
+
+// expandAll expands $(VAR) references in all the given strings.
+func expandAll(input []string, vars []envVar) []string {
+	if len(input) == 0 {
+		return input
+	}
+	values := make(map[string]string, len(vars))
+	for _, v := range vars {
+		values[v.name] = v.value
+	}
+	out := make([]string, len(input))
+	for i, s := range input {
+		out[i] = expandEnv(s, values)
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package tekton
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpandEnv(t *testing.T) {
+	values := map[string]string{"FOO": "foo", "BAR": "bar"}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "$(FOO)", expected: "foo"},
+		{input: "$(FOO)-$(BAR)", expected: "foo-bar"},
+		{input: "$(UNKNOWN)", expected: "$(UNKNOWN)"},
+		{input: "$$(FOO)", expected: "$(FOO)"},
+		{input: "$$$(FOO)", expected: "$foo"},
+		{input: "$FOO", expected: "$FOO"},
+		{input: "$(FOO", expected: "$(FOO"},
+		{input: "trailing$", expected: "trailing$"},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			if got := expandEnv(tc.input, values); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStepEnv(t *testing.T) {
+	optional := true
+	configs := map[string]*corev1.ConfigMap{
+		"config": {
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Data:       map[string]string{"B_KEY": "b", "A_KEY": "a", "1_INVALID": "x"},
+		},
+	}
+	secrets := map[string]*corev1.Secret{
+		"secret": {
+			ObjectMeta: metav1.ObjectMeta{Name: "secret"},
+			Data:       map[string][]byte{"token": []byte("from-data")},
+			StringData: map[string]string{"password": "from-string-data"},
+		},
+	}
+	meta := podMetadata{
+		name:      "run-pod",
+		namespace: "default",
+		labels:    map[string]string{"app": "demo"},
+	}
+	tests := []struct {
+		name     string
+		envFrom  []corev1.EnvFromSource
+		env      []corev1.EnvVar
+		expected []envVar
+		wantErr  bool
+	}{{
+		name: "envFrom-sorted-then-env",
+		envFrom: []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}},
+		}},
+		env: []corev1.EnvVar{{Name: "A_KEY", Value: "override"}, {Name: "C", Value: "$(B_KEY)-c"}},
+		expected: []envVar{
+			{name: "A_KEY", value: "override"},
+			{name: "B_KEY", value: "b"},
+			{name: "C", value: "b-c"},
+		},
+	}, {
+		name: "value-from",
+		env: []corev1.EnvVar{{
+			Name:      "TOKEN",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "token"}},
+		}, {
+			Name:      "PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "password"}},
+		}, {
+			Name:      "A",
+			ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}, Key: "A_KEY"}},
+		}, {
+			Name:      "POD",
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+		}, {
+			Name:      "APP",
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['app']"}},
+		}},
+		expected: []envVar{
+			{name: "TOKEN", value: "from-data"},
+			{name: "PASSWORD", value: "from-string-data"},
+			{name: "A", value: "a"},
+			{name: "POD", value: "run-pod"},
+			{name: "APP", value: "demo"},
+		},
+	}, {
+		name: "optional-missing",
+		envFrom: []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional},
+		}},
+		env: []corev1.EnvVar{{
+			Name:      "MISSING",
+			ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}, Key: "missing", Optional: &optional}},
+		}},
+		expected: []envVar{},
+	}, {
+		name: "missing-envFrom",
+		envFrom: []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}},
+		}},
+		wantErr: true,
+	}, {
+		name: "missing-key",
+		env: []corev1.EnvVar{{
+			Name:      "MISSING",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "missing"}},
+		}},
+		wantErr: true,
+	}, {
+		name: "resource-field-ref",
+		env: []corev1.EnvVar{{
+			Name:      "CPU",
+			ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.cpu"}},
+		}},
+		wantErr: true,
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := stepEnv(tc.envFrom, tc.env, meta, configs, secrets)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.expected, got, cmp.AllowUnexported(envVar{})); d != "" {
+				t.Errorf("unexpected env: %s", d)
+			}
+		})
+	}
+}
@@ -6,27 +6,28 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// ConfigMap creates an LLB state containing the files projected from the
+// given ConfigMap, the same way Kubernetes does for a configMap volume. The
+// configmap can be nil if not found, which is only an error if the source
+// isn't optional.
 func ConfigMap(configmap *corev1.ConfigMap, configmapSource *corev1.ConfigMapVolumeSource) (llb.State, error) {
-	state := llb.Scratch().Dir("/")
-	if len(configmapSource.Items) == 0 {
-		for name, value := range configmap.Data {
-			state = addConfigMap(state, configmap.Name, name, value)
-		}
-	} else {
-		for _, item := range configmapSource.Items {
-			value, ok := configmap.Data[item.Key]
-			if !ok {
-				return llb.State{}, errors.Errorf("key %s from configmap %s not found in context", item.Key, configmap.Name)
-			}
-			state = addConfigMap(state, configmap.Name, item.Path, value)
+	optional := configmapSource.Optional != nil && *configmapSource.Optional
+	if configmap == nil {
+		if optional {
+			return llb.Scratch().Dir("/"), nil
 		}
+		return llb.State{}, errors.Errorf("configmap %s not found in context", configmapSource.Name)
 	}
-	return state, nil
-}
-
-func addConfigMap(state llb.State, configmapName, name, value string) llb.State {
-	return state.File(
-		llb.Mkfile(name, 0755, []byte(value)),
-		llb.WithCustomName("[tekton] configmap "+configmapName+"/"+name+": preparing file"),
-	)
+	data := make(map[string][]byte, len(configmap.Data)+len(configmap.BinaryData))
+	for k, v := range configmap.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range configmap.BinaryData {
+		data[k] = v
+	}
+	files, err := projectedFiles("configmap", configmap.Name, data, configmapSource.Items, configmapSource.DefaultMode, optional)
+	if err != nil {
+		return llb.State{}, err
+	}
+	return project("configmap", configmap.Name, files), nil
 }
@@ -1,8 +1,14 @@
 package files_test
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -68,3 +74,118 @@ func TestConfigMap(t *testing.T) {
 		})
 	}
 }
+
+// fileActions returns the file actions of the given state, once marshalled.
+func fileActions(t *testing.T, st llb.State) []*pb.FileAction {
+	t.Helper()
+	def, err := st.Marshal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []*pb.FileAction{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatal(err)
+		}
+		if file := op.GetFile(); file != nil {
+			actions = append(actions, file.Actions...)
+		}
+	}
+	return actions
+}
+
+func TestConfigMapProjection(t *testing.T) {
+	defaultMode := int32(0600)
+	itemMode := int32(0400)
+	configmap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "myconfigmap"},
+		Data:       map[string]string{"b": "value-b", "a": "value-a"},
+		BinaryData: map[string][]byte{"c": {0x00, 0x01}},
+	}
+	st, err := files.ConfigMap(configmap, &corev1.ConfigMapVolumeSource{DefaultMode: &defaultMode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, a := range fileActions(t, st) {
+		switch {
+		case a.GetMkfile() != nil:
+			got = append(got, fmt.Sprintf("file %s %o %q", a.GetMkfile().Path, a.GetMkfile().Mode, a.GetMkfile().Data))
+		case a.GetMkdir() != nil:
+			got = append(got, "dir "+a.GetMkdir().Path)
+		case a.GetSymlink() != nil:
+			got = append(got, fmt.Sprintf("link %s -> %s", a.GetSymlink().Newpath, a.GetSymlink().Oldpath))
+		}
+	}
+	expected := []string{
+		"dir /..tekton",
+		`file /..tekton/a 600 "value-a"`,
+		`file /..tekton/b 600 "value-b"`,
+		`file /..tekton/c 600 "\x00\x01"`,
+		"link /..data -> ..tekton",
+		"link /a -> ..data/a",
+		"link /b -> ..data/b",
+		"link /c -> ..data/c",
+	}
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Errorf("unexpected projection: %s", d)
+	}
+
+	st, err = files.ConfigMap(configmap, &corev1.ConfigMapVolumeSource{
+		Items: []corev1.KeyToPath{{Key: "a", Path: "nested/a.txt", Mode: &itemMode}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range fileActions(t, st) {
+		if f := a.GetMkfile(); f != nil && (f.Path != "/..tekton/nested/a.txt" || f.Mode != 0400) {
+			t.Errorf("unexpected file %s with mode %o", f.Path, f.Mode)
+		}
+		if l := a.GetSymlink(); l != nil && l.Newpath != "/..data" && l.Newpath != "/nested" {
+			t.Errorf("unexpected symlink %s", l.Newpath)
+		}
+	}
+}
+
+func TestConfigMapDeterministic(t *testing.T) {
+	configmap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "myconfigmap"},
+		Data:       map[string]string{},
+	}
+	for i := 0; i < 20; i++ {
+		configmap.Data[fmt.Sprintf("key%d", i)] = "value"
+	}
+	digests := map[string]struct{}{}
+	for i := 0; i < 10; i++ {
+		st, err := files.ConfigMap(configmap, &corev1.ConfigMapVolumeSource{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		def, err := st.Marshal(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[digest.FromBytes(def.Def[len(def.Def)-1]).String()] = struct{}{}
+	}
+	if len(digests) != 1 {
+		t.Errorf("expected the same configmap to always produce the same LLB, got %d different ones", len(digests))
+	}
+}
+
+func TestConfigMapOptional(t *testing.T) {
+	optional := true
+	if _, err := files.ConfigMap(nil, &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}); err == nil {
+		t.Errorf("expected an error for a missing configmap")
+	}
+	if _, err := files.ConfigMap(nil, &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional}); err != nil {
+		t.Errorf("expected no error for a missing optional configmap, got %v", err)
+	}
+	configmap := &corev1.ConfigMap{Data: map[string]string{"configmap": "value"}}
+	if _, err := files.ConfigMap(configmap, &corev1.ConfigMapVolumeSource{
+		Items:    []corev1.KeyToPath{{Key: "notfound", Path: "foo.txt"}},
+		Optional: &optional,
+	}); err != nil {
+		t.Errorf("expected no error for a missing key of an optional configmap, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package files
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// dataDir is the directory holding the projected files. Kubernetes uses
+	// a timestamped directory (updated atomically), but we need the same
+	// data to always produce the same state (and cache key).
+	dataDir = "..tekton"
+	// dataLink is the symlink pointing to dataDir, the same as Kubernetes.
+	dataLink = "..data"
+	// defaultMode is the default mode of projected files, the same as
+	// Kubernetes (corev1.ConfigMapVolumeSourceDefaultMode and
+	// corev1.SecretVolumeSourceDefaultMode).
+	defaultMode = 0644
+)
+
+// projectedFile is a file projected from a ConfigMap or Secret.
+type projectedFile struct {
+	path string
+	data []byte
+	mode os.FileMode
+}
+
+// SecretData returns the data of a secret, merging StringData into Data the
+// same way the Kubernetes API server does (StringData taking precedence), as
+// secrets are read from files and never go through the API server.
+func SecretData(secret *corev1.Secret) map[string][]byte {
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	for k, v := range secret.StringData {
+		data[k] = []byte(v)
+	}
+	return data
+}
+
+// projectedFiles returns the files to project from data, following the given
+// items (all keys if empty) and modes.
+func projectedFiles(kind, name string, data map[string][]byte, items []corev1.KeyToPath, mode *int32, optional bool) ([]projectedFile, error) {
+	fileMode := os.FileMode(defaultMode)
+	if mode != nil {
+		fileMode = os.FileMode(*mode)
+	}
+	files := []projectedFile{}
+	if len(items) == 0 {
+		for key, value := range data {
+			files = append(files, projectedFile{path: key, data: value, mode: fileMode})
+		}
+	} else {
+		for _, item := range items {
+			value, ok := data[item.Key]
+			if !ok {
+				if optional {
+					continue
+				}
+				return nil, errors.Errorf("key %s from %s %s not found in context", item.Key, kind, name)
+			}
+			itemMode := fileMode
+			if item.Mode != nil {
+				itemMode = os.FileMode(*item.Mode)
+			}
+			if err := validatePath(item.Path); err != nil {
+				return nil, errors.Wrapf(err, "%s %s: item %s", kind, name, item.Key)
+			}
+			files = append(files, projectedFile{path: item.Path, data: value, mode: itemMode})
+		}
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+// sortFiles sorts the files so that the same data always produce the same LLB.
+func sortFiles(files []projectedFile) {
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+}
+
+func validatePath(p string) error {
+	if path.IsAbs(p) {
+		return errors.Errorf("path %s must be relative", p)
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return errors.Errorf("path %s must not contain '..'", p)
+		}
+	}
+	return nil
+}
+
+// project creates a state with the given files, using the same layout as
+// Kubernetes: files are written in a hidden directory, pointed to by the
+// ..data symlink, and each top-level entry is a symlink through ..data.
+func project(kind, name string, files []projectedFile) llb.State {
+	state := llb.Scratch().Dir("/")
+	if len(files) == 0 {
+		return state
+	}
+	action := llb.Mkdir("/"+dataDir, 0755)
+	topLevel := []string{}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if dir := path.Dir(f.path); dir != "." {
+			action = action.Mkdir(path.Join("/", dataDir, dir), 0755, llb.WithParents(true))
+		}
+		action = action.Mkfile(path.Join("/", dataDir, f.path), f.mode, f.data)
+		top := strings.SplitN(f.path, "/", 2)[0]
+		if !seen[top] {
+			seen[top] = true
+			topLevel = append(topLevel, top)
+		}
+	}
+	action = action.Symlink(dataDir, "/"+dataLink)
+	for _, top := range topLevel {
+		action = action.Symlink(path.Join(dataLink, top), "/"+top)
+	}
+	return state.File(action,
+		llb.WithCustomName("[tekton] "+kind+" "+name+": preparing files"),
+	)
+}
@@ -0,0 +1,45 @@
+package files
+
+import (
+	"os"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// FieldValueFunc returns the value of a (downward API) field path of a pod.
+type FieldValueFunc func(path string) (string, error)
+
+// DownwardAPI creates an LLB state containing the files projected from the
+// given downwardAPI volume source, the same way Kubernetes does. Field values
+// are resolved with fieldValue, resourceFieldRef items are not supported.
+func DownwardAPI(name string, source *corev1.DownwardAPIVolumeSource, fieldValue FieldValueFunc) (llb.State, error) {
+	fileMode := os.FileMode(defaultMode)
+	if source.DefaultMode != nil {
+		fileMode = os.FileMode(*source.DefaultMode)
+	}
+	files := make([]projectedFile, 0, len(source.Items))
+	for _, item := range source.Items {
+		if item.ResourceFieldRef != nil {
+			return llb.State{}, errors.Errorf("downwardAPI %s: item %s: resourceFieldRef not supported", name, item.Path)
+		}
+		if item.FieldRef == nil {
+			return llb.State{}, errors.Errorf("downwardAPI %s: item %s: no fieldRef", name, item.Path)
+		}
+		if err := validatePath(item.Path); err != nil {
+			return llb.State{}, errors.Wrapf(err, "downwardAPI %s", name)
+		}
+		value, err := fieldValue(item.FieldRef.FieldPath)
+		if err != nil {
+			return llb.State{}, errors.Wrapf(err, "downwardAPI %s: item %s", name, item.Path)
+		}
+		itemMode := fileMode
+		if item.Mode != nil {
+			itemMode = os.FileMode(*item.Mode)
+		}
+		files = append(files, projectedFile{path: item.Path, data: []byte(value), mode: itemMode})
+	}
+	sortFiles(files)
+	return project("downwardAPI", name, files), nil
+}
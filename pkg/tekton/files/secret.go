@@ -6,27 +6,20 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// Secret creates an LLB state containing the files projected from the given
+// Secret, the same way Kubernetes does for a secret volume. The secret can
+// be nil if not found, which is only an error if the source isn't optional.
 func Secret(secret *corev1.Secret, secretSource *corev1.SecretVolumeSource) (llb.State, error) {
-	state := llb.Scratch().Dir("/")
-	if len(secretSource.Items) == 0 {
-		for name, value := range secret.Data {
-			state = addSecret(state, secret.Name, name, value)
-		}
-	} else {
-		for _, item := range secretSource.Items {
-			value, ok := secret.Data[item.Key]
-			if !ok {
-				return llb.State{}, errors.Errorf("key %s from secret %s not found in context", item.Key, secret.Name)
-			}
-			state = addSecret(state, secret.Name, item.Path, value)
+	optional := secretSource.Optional != nil && *secretSource.Optional
+	if secret == nil {
+		if optional {
+			return llb.Scratch().Dir("/"), nil
 		}
+		return llb.State{}, errors.Errorf("secret %s not found in context", secretSource.SecretName)
 	}
-	return state, nil
-}
-
-func addSecret(state llb.State, secretName, name string, value []byte) llb.State {
-	return state.File(
-		llb.Mkfile(name, 0755, value),
-		llb.WithCustomName("[tekton] secret "+secretName+"/"+name+": preparing file"),
-	)
+	files, err := projectedFiles("secret", secret.Name, SecretData(secret), secretSource.Items, secretSource.DefaultMode, optional)
+	if err != nil {
+		return llb.State{}, err
+	}
+	return project("secret", secret.Name, files), nil
 }
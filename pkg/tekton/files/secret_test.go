@@ -68,3 +68,29 @@ func TestSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestSecretStringData(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysecret"},
+		Data:       map[string][]byte{"password": []byte("from-data"), "token": []byte("token")},
+		StringData: map[string]string{"password": "from-string-data"},
+	}
+	st, err := files.Secret(secret, &corev1.SecretVolumeSource{
+		Items: []corev1.KeyToPath{{Key: "password", Path: "password"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range fileActions(t, st) {
+		if f := a.GetMkfile(); f != nil {
+			found = true
+			if string(f.Data) != "from-string-data" || f.Mode != 0644 {
+				t.Errorf("unexpected file %s with mode %o and data %q", f.Path, f.Mode, f.Data)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the password file to be projected")
+	}
+}
@@ -0,0 +1,27 @@
+package tekton
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// resolveImageConfig fetches the configuration (entrypoint, cmd, env, …) of
+// the given image.
+func resolveImageConfig(ctx context.Context, c client.Client, image string) (ocispecs.ImageConfig, error) {
+	_, _, dt, err := c.ResolveImageConfig(ctx, image, sourceresolver.Opt{
+		ImageOpt: &sourceresolver.ResolveImageOpt{},
+	})
+	if err != nil {
+		return ocispecs.ImageConfig{}, errors.Wrapf(err, "failed to resolve image config for %s", image)
+	}
+	var img ocispecs.Image
+	if err := json.Unmarshal(dt, &img); err != nil {
+		return ocispecs.ImageConfig{}, errors.Wrapf(err, "failed to parse image config for %s", image)
+	}
+	return img.Config, nil
+}
@@ -1,114 +1,302 @@
 package tekton
 
 import (
-	"regexp"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"strings"
+	"sync"
 
+	"github.com/moby/buildkit/client/llb"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
 	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	k8scheme "k8s.io/client-go/kubernetes/scheme"
 )
 
 type objects struct {
-	tasks        []*v1.Task
-	taskruns     []*v1.TaskRun
-	pipelines    []*v1.Pipeline
-	pipelineruns []*v1.PipelineRun
-	secrets      []*corev1.Secret
-	configs      []*corev1.ConfigMap
+	tasks           []*v1.Task
+	taskruns        []*v1.TaskRun
+	pipelines       []*v1.Pipeline
+	pipelineruns    []*v1.PipelineRun
+	stepActions     []*v1beta1.StepAction
+	secrets         []*corev1.Secret
+	configs         []*corev1.ConfigMap
+	serviceAccounts []*corev1.ServiceAccount
 }
 
 type TaskRun struct {
-	main    *v1.TaskRun
-	tasks   map[string]*v1.Task
-	secrets map[string]*corev1.Secret
-	configs map[string]*corev1.ConfigMap
+	main            *v1.TaskRun
+	tasks           map[string]*v1.Task
+	stepActions     map[string]*v1beta1.StepAction
+	secrets         map[string]*corev1.Secret
+	configs         map[string]*corev1.ConfigMap
+	serviceAccounts map[string]*corev1.ServiceAccount
+	locations       locations
 }
 
 type PipelineRun struct {
-	main      *v1.PipelineRun
-	tasks     map[string]*v1.Task
-	pipelines map[string]*v1.Pipeline
-	secrets   map[string]*corev1.Secret
-	configs   map[string]*corev1.ConfigMap
+	main            *v1.PipelineRun
+	tasks           map[string]*v1.Task
+	pipelines       map[string]*v1.Pipeline
+	stepActions     map[string]*v1beta1.StepAction
+	secrets         map[string]*corev1.Secret
+	configs         map[string]*corev1.ConfigMap
+	serviceAccounts map[string]*corev1.ServiceAccount
+	locations       locations
 }
 
-var (
-	reg = regexp.MustCompile(`(?m)^\s*#([^#].*?)$`)
-)
+// supportedKinds are the kinds that can be loaded, any other document is
+// skipped. v1beta1 resources (and v1alpha1 StepActions) are converted.
+var supportedKinds = map[schema.GroupVersionKind]bool{
+	v1.SchemeGroupVersion.WithKind("Task"):               true,
+	v1.SchemeGroupVersion.WithKind("TaskRun"):            true,
+	v1.SchemeGroupVersion.WithKind("Pipeline"):           true,
+	v1.SchemeGroupVersion.WithKind("PipelineRun"):        true,
+	v1beta1.SchemeGroupVersion.WithKind("Task"):          true,
+	v1beta1.SchemeGroupVersion.WithKind("TaskRun"):       true,
+	v1beta1.SchemeGroupVersion.WithKind("Pipeline"):      true,
+	v1beta1.SchemeGroupVersion.WithKind("PipelineRun"):   true,
+	v1beta1.SchemeGroupVersion.WithKind("StepAction"):    true,
+	v1alpha1.SchemeGroupVersion.WithKind("StepAction"):   true,
+	corev1.SchemeGroupVersion.WithKind("Secret"):         true,
+	corev1.SchemeGroupVersion.WithKind("ConfigMap"):      true,
+	corev1.SchemeGroupVersion.WithKind("ServiceAccount"): true,
+}
+
+// ContextResource is a file of the context resources are loaded from.
+type ContextResource struct {
+	// Path is the path of the file in the context
+	Path string
+	// Data is the content of the file
+	Data string
+	// SourceMap is the source map of the file, if any, to report locations
+	// (e.g. of warnings) in it.
+	SourceMap *llb.SourceMap
+}
 
-func readResources(main string, additionals []string) (interface{}, error) {
-	s := k8scheme.Scheme
-	if err := v1.AddToScheme(s); err != nil {
+// readResources reads the runs of the main file to execute (see selectRuns),
+// with the resources they reference, from the main file and the context.
+func readResources(main ContextResource, additionals []ContextResource, selected string) ([]interface{}, error) {
+	if err := addToScheme(k8scheme.Scheme); err != nil {
 		return nil, err
 	}
-	objs, err := parseTektonYAMLs(main)
+	objs, err := parseTektonYAMLs(main.Data)
 	if err != nil {
 		return nil, err
 	}
-	switch {
-	case len(objs.taskruns) == 1 && len(objs.pipelineruns) == 0:
-		r := TaskRun{
-			main:    objs.taskruns[0],
-			secrets: secretsToMap(objs.secrets),
-			configs: configsToMap(objs.configs),
-			tasks:   map[string]*v1.Task{},
-		}
-		return populateTaskRun(r, additionals)
-	case len(objs.taskruns) == 0 && len(objs.pipelineruns) == 1:
-		r := PipelineRun{
-			main:      objs.pipelineruns[0],
-			secrets:   secretsToMap(objs.secrets),
-			configs:   configsToMap(objs.configs),
-			tasks:     map[string]*v1.Task{},
-			pipelines: map[string]*v1.Pipeline{},
-		}
-		return populatePipelineRun(r, additionals)
-	case len(objs.taskruns) == 0 && len(objs.pipelineruns) == 0:
-		return nil, errors.New("No taskrun or pipelinern to run")
-	case len(objs.taskruns) == 1 && len(objs.pipelineruns) == 1:
-		return nil, errors.New("taskrun and pipelinerun both present, not supported")
-	case len(objs.taskruns) > 1 || len(objs.pipelineruns) > 1:
-		return nil, errors.New(" multiple taskruns and/or pipelineruns present, not supported")
+	// Runs are located before being named (see nameRuns)
+	runLocations := locations{}
+	for _, tr := range objs.taskruns {
+		runLocations[tr] = locate(&main, "TaskRun", tr.ObjectMeta)
+	}
+	for _, pr := range objs.pipelineruns {
+		runLocations[pr] = locate(&main, "PipelineRun", pr.ObjectMeta)
+	}
+	selectedRuns, err := selectRuns(objs, selected)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]interface{}, 0, len(selectedRuns))
+	for _, run := range selectedRuns {
+		switch o := run.(type) {
+		case *v1.TaskRun:
+			r := TaskRun{
+				main:            o,
+				secrets:         secretsToMap(objs.secrets),
+				configs:         configsToMap(objs.configs),
+				serviceAccounts: serviceAccountsToMap(objs.serviceAccounts),
+				stepActions:     stepActionsToMap(objs.stepActions),
+				tasks:           map[string]*v1.Task{},
+				locations:       locations{o: runLocations[o]},
+			}
+			populated, err := populateTaskRun(r, additionals)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, populated)
+		case *v1.PipelineRun:
+			r := PipelineRun{
+				main:            o,
+				secrets:         secretsToMap(objs.secrets),
+				configs:         configsToMap(objs.configs),
+				serviceAccounts: serviceAccountsToMap(objs.serviceAccounts),
+				stepActions:     stepActionsToMap(objs.stepActions),
+				tasks:           map[string]*v1.Task{},
+				pipelines:       map[string]*v1.Pipeline{},
+				locations:       locations{o: runLocations[o]},
+			}
+			populated, err := populatePipelineRun(r, additionals)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, populated)
+		}
+	}
+	return runs, nil
+}
+
+// selectRuns returns the runs (TaskRuns and PipelineRuns) of the main file to
+// execute: the only one, the one matching selected (by name or generateName,
+// with or without its trailing dash), or all of them with config.AllRuns.
+func selectRuns(objs *objects, selected string) ([]interface{}, error) {
+	runs := []interface{}{}
+	for _, tr := range objs.taskruns {
+		runs = append(runs, tr)
+	}
+	for _, pr := range objs.pipelineruns {
+		runs = append(runs, pr)
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("No taskrun or pipelinerun to run")
+	}
+	switch selected {
+	case "":
+		if len(runs) > 1 {
+			return nil, errors.Errorf("multiple runs present (%s), select one with --opt run=<name> or all of them with --opt run=%s", strings.Join(runNames(runs), ", "), config.AllRuns)
+		}
+		return runs, nil
+	case config.AllRuns:
+		if err := nameRuns(runs); err != nil {
+			return nil, err
+		}
+		return runs, nil
+	}
+	matching := []interface{}{}
+	for _, run := range runs {
+		if runMatches(runMeta(run), selected) {
+			matching = append(matching, run)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return nil, errors.Errorf("run %s not found, available runs: %s", selected, strings.Join(runNames(runs), ", "))
+	case 1:
+		return matching, nil
 	default:
-		return nil, errors.Errorf("Document doesn't look like a tekton resource we can Resolve. %s", main)
+		return nil, errors.Errorf("run %s matches several runs (%s)", selected, strings.Join(runNames(matching), ", "))
 	}
 }
 
-func populateTaskRun(r TaskRun, additionals []string) (TaskRun, error) {
-	for _, data := range additionals {
-		for _, doc := range strings.Split(strings.Trim(reg.ReplaceAllString(data, ""), "-"), "---") {
-			obj, err := parseTektonYAML(doc)
-			if err != nil {
-				return r, errors.Wrapf(err, "failed to unmarshal %v", doc)
-			}
+// runMeta returns the metadata of a TaskRun or PipelineRun.
+func runMeta(run interface{}) *metav1.ObjectMeta {
+	switch o := run.(type) {
+	case *v1.TaskRun:
+		return &o.ObjectMeta
+	case *v1.PipelineRun:
+		return &o.ObjectMeta
+	}
+	return &metav1.ObjectMeta{}
+}
+
+func runMatches(meta *metav1.ObjectMeta, selected string) bool {
+	if meta.Name != "" {
+		return meta.Name == selected
+	}
+	return meta.GenerateName != "" && (meta.GenerateName == selected || strings.TrimSuffix(meta.GenerateName, "-") == selected)
+}
+
+// runName returns the name of a TaskRun or PipelineRun, or its generateName.
+func runName(run interface{}) string {
+	meta := runMeta(run)
+	if meta.Name == "" {
+		return meta.GenerateName
+	}
+	return meta.Name
+}
+
+func runNames(runs []interface{}) []string {
+	names := make([]string, len(runs))
+	for i, run := range runs {
+		names[i] = runName(run)
+	}
+	return names
+}
+
+// nameRuns gives a unique name to the runs executed together, as their name
+// keys their caches (e.g. the results of a TaskRun, or of the tasks of a
+// PipelineRun, see pipelineTaskName). Runs using generateName are named after
+// their position in the main file.
+func nameRuns(runs []interface{}) error {
+	names := map[string]bool{}
+	for i, run := range runs {
+		meta := runMeta(run)
+		if meta.Name == "" && meta.GenerateName != "" {
+			meta.Name = fmt.Sprintf("%s%d", meta.GenerateName, i+1)
+		}
+		if names[meta.Name] {
+			return errors.Errorf("multiple runs named %s", meta.Name)
+		}
+		names[meta.Name] = true
+	}
+	return nil
+}
+
+func populateTaskRun(r TaskRun, additionals []ContextResource) (TaskRun, error) {
+	for i := range additionals {
+		additional := &additionals[i]
+		objs, err := parseDocuments(additional.Data)
+		if err != nil {
+			// The context may contain any YAML file, not only Tekton ones
+			logrus.Warnf("Skipping %s: %v", additional.Path, err)
+			continue
+		}
+		for _, obj := range objs {
 			switch o := obj.(type) {
 			case *v1.Task:
 				r.tasks[o.Name] = o
-			default:
-				logrus.Infof("Skipping document not looking like a tekton resource we can Resolve.")
+				r.locations[o] = locate(additional, "Task", o.ObjectMeta)
+			case *v1beta1.StepAction:
+				addStepAction(r.stepActions, o)
+			case *corev1.Secret:
+				addSecret(r.secrets, o)
+			case *corev1.ConfigMap:
+				addConfig(r.configs, o)
+			case *corev1.ServiceAccount:
+				addServiceAccount(r.serviceAccounts, o)
 			}
 		}
 	}
 	return r, nil
 }
 
-func populatePipelineRun(r PipelineRun, additionals []string) (PipelineRun, error) {
-	for _, data := range additionals {
-		for _, doc := range strings.Split(strings.Trim(reg.ReplaceAllString(data, ""), "-"), "---") {
-			obj, err := parseTektonYAML(doc)
-			if err != nil {
-				return r, errors.Wrapf(err, "failed to unmarshal %v", doc)
-			}
+func populatePipelineRun(r PipelineRun, additionals []ContextResource) (PipelineRun, error) {
+	for i := range additionals {
+		additional := &additionals[i]
+		objs, err := parseDocuments(additional.Data)
+		if err != nil {
+			// The context may contain any YAML file, not only Tekton ones
+			logrus.Warnf("Skipping %s: %v", additional.Path, err)
+			continue
+		}
+		for _, obj := range objs {
 			switch o := obj.(type) {
 			case *v1.Task:
 				r.tasks[o.Name] = o
+				r.locations[o] = locate(additional, "Task", o.ObjectMeta)
+			case *v1beta1.StepAction:
+				addStepAction(r.stepActions, o)
 			case *v1.Pipeline:
 				r.pipelines[o.Name] = o
-			default:
-				logrus.Infof("Skipping document not looking like a tekton resource we can Resolve.")
+				r.locations[o] = locate(additional, "Pipeline", o.ObjectMeta)
+			case *corev1.Secret:
+				addSecret(r.secrets, o)
+			case *corev1.ConfigMap:
+				addConfig(r.configs, o)
+			case *corev1.ServiceAccount:
+				addServiceAccount(r.serviceAccounts, o)
 			}
 		}
 	}
@@ -117,19 +305,21 @@ func populatePipelineRun(r PipelineRun, additionals []string) (PipelineRun, erro
 
 func parseTektonYAMLs(s string) (*objects, error) {
 	r := &objects{
-		tasks:        []*v1.Task{},
-		taskruns:     []*v1.TaskRun{},
-		pipelines:    []*v1.Pipeline{},
-		pipelineruns: []*v1.PipelineRun{},
-		secrets:      []*corev1.Secret{},
-		configs:      []*corev1.ConfigMap{},
+		tasks:           []*v1.Task{},
+		taskruns:        []*v1.TaskRun{},
+		pipelines:       []*v1.Pipeline{},
+		pipelineruns:    []*v1.PipelineRun{},
+		stepActions:     []*v1beta1.StepAction{},
+		secrets:         []*corev1.Secret{},
+		configs:         []*corev1.ConfigMap{},
+		serviceAccounts: []*corev1.ServiceAccount{},
 	}
 
-	for _, doc := range strings.Split(strings.Trim(reg.ReplaceAllString(s, ""), "-"), "---") {
-		obj, err := parseTektonYAML(doc)
-		if err != nil {
-			return r, errors.Wrapf(err, "failed to unmarshal %v", doc)
-		}
+	objs, err := parseDocuments(s)
+	if err != nil {
+		return r, err
+	}
+	for _, obj := range objs {
 		switch o := obj.(type) {
 		case *v1.Task:
 			r.tasks = append(r.tasks, o)
@@ -139,10 +329,14 @@ func parseTektonYAMLs(s string) (*objects, error) {
 			r.pipelines = append(r.pipelines, o)
 		case *v1.PipelineRun:
 			r.pipelineruns = append(r.pipelineruns, o)
+		case *v1beta1.StepAction:
+			r.stepActions = append(r.stepActions, o)
 		case *corev1.Secret:
 			r.secrets = append(r.secrets, o)
 		case *corev1.ConfigMap:
 			r.configs = append(r.configs, o)
+		case *corev1.ServiceAccount:
+			r.serviceAccounts = append(r.serviceAccounts, o)
 		}
 	}
 	return r, nil
@@ -157,6 +351,137 @@ func parseTektonYAML(s string) (interface{}, error) {
 	return obj, nil
 }
 
+// documentCache caches the objects decoded from a stream, by digest of the
+// stream, as the same files are read for each run.
+var documentCache = struct {
+	sync.Mutex
+	objects map[digest.Digest][]runtime.Object
+}{objects: map[digest.Digest][]runtime.Object{}}
+
+// parseDocuments decodes the supported objects of a multi-document YAML (or
+// JSON) stream. Documents are decoded as a whole, so block scalars (e.g. a
+// script with comments or ---) are kept intact. Empty documents and documents
+// of unsupported kinds are skipped. Decoded objects are cached, callers get
+// their own copy.
+func parseDocuments(s string) ([]interface{}, error) {
+	dgst := digest.FromString(s)
+	documentCache.Lock()
+	cached, ok := documentCache.objects[dgst]
+	documentCache.Unlock()
+	if !ok {
+		var err error
+		cached, err = decodeDocuments(s)
+		if err != nil {
+			return nil, err
+		}
+		documentCache.Lock()
+		documentCache.objects[dgst] = cached
+		documentCache.Unlock()
+	}
+	objs := make([]interface{}, len(cached))
+	for i, obj := range cached {
+		objs[i] = obj.DeepCopyObject()
+	}
+	return objs, nil
+}
+
+// decodeDocuments decodes the supported objects of a multi-document YAML (or
+// JSON) stream, see parseDocuments.
+func decodeDocuments(s string) ([]runtime.Object, error) {
+	lines := documentLines(s)
+	objs := []runtime.Object{}
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(s), 4096)
+	for i := 0; ; i++ {
+		position := documentPosition(i, lines)
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, position)
+		}
+		if len(doc) == 0 || string(doc) == "null" {
+			continue
+		}
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(doc, &typeMeta); err != nil {
+			return nil, errors.Wrap(err, position)
+		}
+		if typeMeta.APIVersion == "" || typeMeta.Kind == "" {
+			return nil, errors.Errorf("%s: apiVersion and kind are required", position)
+		}
+		if gvk := typeMeta.GroupVersionKind(); !supportedKinds[gvk] {
+			if gvk.Group == pipeline.GroupName {
+				logrus.Warnf("Skipping %s: %s %s is not supported", position, typeMeta.APIVersion, typeMeta.Kind)
+			} else {
+				logrus.Infof("Skipping %s: %s %s is not a Tekton resource", position, typeMeta.APIVersion, typeMeta.Kind)
+			}
+			continue
+		}
+		obj, err := parseTektonYAML(string(doc))
+		if err != nil {
+			return nil, errors.Wrap(err, position)
+		}
+		converted, err := convertToV1(context.Background(), obj.(runtime.Object))
+		if err != nil {
+			return nil, errors.Wrap(err, position)
+		}
+		objs = append(objs, converted)
+	}
+	return objs, nil
+}
+
+// documentLines returns the line each document of a YAML stream starts at,
+// splitting documents the same way the YAML decoder does. It returns nothing
+// for a JSON stream.
+func documentLines(s string) []int {
+	if _, _, isJSON := yaml.GuessJSONStream(strings.NewReader(s), 4096); isJSON {
+		return nil
+	}
+	lines := []int{}
+	inDocument := false
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	scanner.Buffer(make([]byte, 4096), len(s)+1)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.HasPrefix(scanner.Text(), "---") {
+			inDocument = false
+			continue
+		}
+		if !inDocument {
+			lines = append(lines, n)
+			inDocument = true
+		}
+	}
+	return lines
+}
+
+// documentPosition describes the position of the i-th document of a stream,
+// for errors and warnings.
+func documentPosition(i int, lines []int) string {
+	if i < len(lines) {
+		return fmt.Sprintf("document %d (line %d)", i+1, lines[i])
+	}
+	return fmt.Sprintf("document %d", i+1)
+}
+
+// addToScheme registers the Tekton types that can be loaded in the scheme.
+func addToScheme(s *runtime.Scheme) error {
+	for _, add := range []func(*runtime.Scheme) error{v1.AddToScheme, v1beta1.AddToScheme, v1alpha1.AddToScheme} {
+		if err := add(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stepActionsToMap(stepActions []*v1beta1.StepAction) map[string]*v1beta1.StepAction {
+	m := map[string]*v1beta1.StepAction{}
+	for _, sa := range stepActions {
+		m[sa.Name] = sa
+	}
+	return m
+}
+
 func secretsToMap(secrets []*corev1.Secret) map[string]*corev1.Secret {
 	m := map[string]*corev1.Secret{}
 	for _, s := range secrets {
@@ -172,3 +497,37 @@ func configsToMap(configs []*corev1.ConfigMap) map[string]*corev1.ConfigMap {
 	}
 	return m
 }
+
+func serviceAccountsToMap(serviceAccounts []*corev1.ServiceAccount) map[string]*corev1.ServiceAccount {
+	m := map[string]*corev1.ServiceAccount{}
+	for _, sa := range serviceAccounts {
+		m[sa.Name] = sa
+	}
+	return m
+}
+
+// addSecret, addConfig, addServiceAccount and addStepAction register objects
+// found in the context. Objects from the main file take precedence.
+func addSecret(m map[string]*corev1.Secret, s *corev1.Secret) {
+	if _, ok := m[s.Name]; !ok {
+		m[s.Name] = s
+	}
+}
+
+func addConfig(m map[string]*corev1.ConfigMap, c *corev1.ConfigMap) {
+	if _, ok := m[c.Name]; !ok {
+		m[c.Name] = c
+	}
+}
+
+func addServiceAccount(m map[string]*corev1.ServiceAccount, sa *corev1.ServiceAccount) {
+	if _, ok := m[sa.Name]; !ok {
+		m[sa.Name] = sa
+	}
+}
+
+func addStepAction(m map[string]*v1beta1.StepAction, sa *v1beta1.StepAction) {
+	if _, ok := m[sa.Name]; !ok {
+		m[sa.Name] = sa
+	}
+}
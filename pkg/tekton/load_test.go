@@ -1,6 +1,7 @@
 package tekton
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"strings"
@@ -9,7 +10,9 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"github.com/tektoncd/pipeline/test/diff"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8scheme "k8s.io/client-go/kubernetes/scheme"
 )
@@ -30,6 +33,8 @@ func TestReadResources(t *testing.T) {
 		main: "taskrun-with-volumes-and-onerror.yaml",
 	}, {
 		main: "pipelinerun-with-finally.yaml",
+	}, {
+		main: "v1beta1-pipelinerun.yaml",
 	}}
 	for _, tc := range tt {
 		tc := tc
@@ -44,15 +49,15 @@ func testReadResources(main string, additionals []string) func(*testing.T) {
 		if err != nil {
 			t.Fatalf("ReadFile() = %v", err)
 		}
-		a := []string{}
+		a := []ContextResource{}
 		for _, ad := range additionals {
 			d, err := ioutil.ReadFile(fmt.Sprintf("testdata/%s", ad))
 			if err != nil {
 				t.Fatalf("ReadFile() = %v", err)
 			}
-			a = append(a, string(d))
+			a = append(a, ContextResource{Path: ad, Data: string(d)})
 		}
-		_, err = readResources(string(m), a)
+		_, err = readResources(ContextResource{Path: "main.yaml", Data: string(m)}, a, "")
 		if err != nil {
 			t.Fatalf("readResources() = %v", err)
 		}
@@ -240,3 +245,307 @@ spec:
 		})
 	}
 }
+
+func TestParseDocuments(t *testing.T) {
+	s := k8scheme.Scheme
+	if err := v1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	stream := `# A comment before the first document
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ignored
+---
+apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: render
+spec:
+  steps:
+  - name: render
+    image: bash:latest
+    script: |
+      #!/usr/bin/env bash
+      # keep this comment
+      cat <<EOF
+      ---
+      title: hello
+      ---
+      EOF
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  foo: bar
+`
+	objs, err := parseDocuments(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected 2 objects (deployment skipped), got %d", len(objs))
+	}
+	task, ok := objs[0].(*v1.Task)
+	if !ok {
+		t.Fatalf("expected a Task, got %T", objs[0])
+	}
+	expected := "#!/usr/bin/env bash\n# keep this comment\ncat <<EOF\n---\ntitle: hello\n---\nEOF\n"
+	if d := cmp.Diff(expected, task.Spec.Steps[0].Script); d != "" {
+		t.Errorf("script mismatch %s", diff.PrintWantGot(d))
+	}
+	if _, ok := objs[1].(*corev1.ConfigMap); !ok {
+		t.Errorf("expected a ConfigMap, got %T", objs[1])
+	}
+}
+
+func TestParseDocumentsJSON(t *testing.T) {
+	s := k8scheme.Scheme
+	if err := v1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := parseDocuments(`{"apiVersion": "tekton.dev/v1", "kind": "Task", "metadata": {"name": "a"}}
+{"apiVersion": "tekton.dev/v1", "kind": "Task", "metadata": {"name": "b"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected 2 objects, got %d", len(objs))
+	}
+}
+
+func TestParseDocumentsErrors(t *testing.T) {
+	s := k8scheme.Scheme
+	if err := v1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		yaml     string
+		expected string
+	}{{
+		yaml: `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: valid
+---
+apiVersion: tekton.dev/v1
+kind: Task
+spec:
+  steps: "foo"`,
+		expected: "document 2 (line 6)",
+	}, {
+		yaml: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: valid
+---
+
+kind: Task
+metadata:
+  name: no-api-version`,
+		expected: "document 2 (line 6): apiVersion and kind are required",
+	}, {
+		yaml: `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: valid
+---
+apiVersion: tekton.dev/v1
+kind: Task
+ metadata: invalid`,
+		expected: "document 2 (line 6)",
+	}}
+	for i, tc := range tt {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			_, err := parseDocuments(tc.yaml)
+			if err == nil {
+				t.Fatalf("parseDocuments should have failed with %s", tc.yaml)
+			}
+			if !strings.HasPrefix(err.Error(), tc.expected) {
+				t.Errorf("expected error starting with %q, got %q", tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseDocumentsConversion(t *testing.T) {
+	if err := addToScheme(k8scheme.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := parseDocuments(`apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: deprecated
+spec:
+  steps:
+  - name: print
+    image: alpine
+    tty: true
+    script: echo hello
+---
+apiVersion: tekton.dev/v1beta1
+kind: TaskRun
+metadata:
+  name: run
+spec:
+  serviceAccountName: builder
+  taskRef:
+    name: deprecated
+---
+apiVersion: tekton.dev/v1alpha1
+kind: StepAction
+metadata:
+  name: action
+spec:
+  image: alpine
+  script: echo action
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 3 {
+		t.Fatalf("expected 3 objects, got %d", len(objs))
+	}
+	task, ok := objs[0].(*v1.Task)
+	if !ok {
+		t.Fatalf("expected a v1 Task, got %T", objs[0])
+	}
+	if d := cmp.Diff("echo hello", task.Spec.Steps[0].Script); d != "" {
+		t.Errorf("script mismatch %s", diff.PrintWantGot(d))
+	}
+	if _, ok := task.Annotations[v1beta1.TaskDeprecationsAnnotationKey]; !ok {
+		t.Errorf("expected the deprecated tty field to be serialized in annotations, got %v", task.Annotations)
+	}
+	c := &fakeClient{}
+	warnDeprecations(context.Background(), c, location{}, "Task "+task.Name, task.Annotations)
+	if len(c.warnings) != 1 || !strings.HasPrefix(c.warnings[0], "Task deprecated: deprecated v1beta1 fields are ignored ("+v1beta1.TaskDeprecationsAnnotationKey+")") {
+		t.Errorf("expected a warning about the deprecated tty field, got %v", c.warnings)
+	}
+	taskRun, ok := objs[1].(*v1.TaskRun)
+	if !ok {
+		t.Fatalf("expected a v1 TaskRun, got %T", objs[1])
+	}
+	if taskRun.Spec.ServiceAccountName != "builder" || taskRun.Spec.TaskRef.Name != "deprecated" {
+		t.Errorf("unexpected TaskRun spec %+v", taskRun.Spec)
+	}
+	if _, ok := objs[2].(*v1beta1.StepAction); !ok {
+		t.Errorf("expected a v1beta1 StepAction, got %T", objs[2])
+	}
+}
+
+func TestParseDocumentsCache(t *testing.T) {
+	s := k8scheme.Scheme
+	if err := v1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	yaml := `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: cached
+`
+	first, err := parseDocuments(yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first[0].(*v1.Task).Name = "modified"
+	second, err := parseDocuments(yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff("cached", second[0].(*v1.Task).Name); d != "" {
+		t.Errorf("cached objects should not be shared %s", diff.PrintWantGot(d))
+	}
+}
+
+func TestReadResourcesSkipsInvalidContextFiles(t *testing.T) {
+	s := k8scheme.Scheme
+	if err := v1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	main := `apiVersion: tekton.dev/v1
+kind: TaskRun
+metadata:
+  name: run
+spec:
+  taskRef:
+    name: task
+`
+	additionals := []ContextResource{{
+		Path: "charts/values.yaml",
+		Data: "image: [unclosed",
+	}, {
+		Path: "tekton/task.yaml",
+		Data: `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: task
+`,
+	}}
+	r, err := readResources(ContextResource{Path: "main.yaml", Data: main}, additionals, "")
+	if err != nil {
+		t.Fatalf("readResources() = %v", err)
+	}
+	if _, ok := r[0].(TaskRun).tasks["task"]; !ok {
+		t.Errorf("expected task to be loaded from the context")
+	}
+}
+
+func TestSelectRuns(t *testing.T) {
+	objs := func() *objects {
+		return &objects{
+			taskruns: []*v1.TaskRun{
+				{ObjectMeta: metav1.ObjectMeta{GenerateName: "simple-task-"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "named"}},
+			},
+			pipelineruns: []*v1.PipelineRun{
+				{ObjectMeta: metav1.ObjectMeta{GenerateName: "simple-pipeline-"}},
+			},
+		}
+	}
+	tt := []struct {
+		selected string
+		expected []string
+		err      string
+	}{{
+		selected: "named",
+		expected: []string{"named"},
+	}, {
+		selected: "simple-task",
+		expected: []string{"simple-task-"},
+	}, {
+		selected: "simple-pipeline-",
+		expected: []string{"simple-pipeline-"},
+	}, {
+		selected: "*",
+		expected: []string{"simple-task-1", "named", "simple-pipeline-3"},
+	}, {
+		selected: "",
+		err:      "multiple runs present (simple-task-, named, simple-pipeline-)",
+	}, {
+		selected: "unknown",
+		err:      "run unknown not found",
+	}}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.selected, func(t *testing.T) {
+			runs, err := selectRuns(objs(), tc.selected)
+			if tc.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+					t.Fatalf("expected error starting with %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.expected, runNames(runs)); d != "" {
+				t.Errorf("selected runs mismatch %s", diff.PrintWantGot(d))
+			}
+		})
+	}
+}
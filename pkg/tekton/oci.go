@@ -11,65 +11,79 @@ import (
 	k8scheme "k8s.io/client-go/kubernetes/scheme"
 )
 
-func resolveTaskInBundle(ctx context.Context, c client.Client, bundle, name string) (*v1.Task, error) {
+// resolveTaskInBundle returns a Task from an OCI bundle, with its location in
+// the bundle.
+func resolveTaskInBundle(ctx context.Context, c client.Client, bundle, name string) (*v1.Task, location, error) {
 	var task *v1.Task
-	obj, err := resolveBundle(ctx, c, bundle, name)
+	obj, resource, err := resolveBundle(ctx, c, bundle, name)
 	if err != nil {
-		return nil, err
+		return nil, location{}, err
 	}
 	switch o := obj.(type) {
 	case *v1.Task:
 		task = o
 	default:
-		return nil, errors.Errorf("Unknow type: %+v", o)
+		return nil, location{}, errors.Errorf("Unknow type: %+v", o)
 	}
 	task.SetDefaults(ctx)
-	return task, nil
+	return task, locate(resource, "Task", task.ObjectMeta), nil
 }
 
-func resolvePipelineInBundle(ctx context.Context, c client.Client, bundle, name string) (*v1.Pipeline, error) {
+// resolvePipelineInBundle returns a Pipeline from an OCI bundle, with its
+// location in the bundle.
+func resolvePipelineInBundle(ctx context.Context, c client.Client, bundle, name string) (*v1.Pipeline, location, error) {
 	var pipeline *v1.Pipeline
-	obj, err := resolveBundle(ctx, c, bundle, name)
+	obj, resource, err := resolveBundle(ctx, c, bundle, name)
 	if err != nil {
-		return nil, err
+		return nil, location{}, err
 	}
 	switch o := obj.(type) {
 	case *v1.Pipeline:
 		pipeline = o
 	default:
-		return nil, errors.Errorf("Unknow type: %+v", o)
+		return nil, location{}, errors.Errorf("Unknow type: %+v", o)
 	}
 	pipeline.SetDefaults(ctx)
-	return pipeline, nil
+	return pipeline, locate(resource, "Pipeline", pipeline.ObjectMeta), nil
 }
 
-func resolveBundle(ctx context.Context, c client.Client, bundle, name string) (runtime.Object, error) {
-	def, err := llb.Image(bundle).Marshal(ctx)
+// resolveBundle reads a resource from an OCI bundle. The file it is read from
+// is returned too, with a source map on the bundle image, to locate it.
+func resolveBundle(ctx context.Context, c client.Client, bundle, name string) (runtime.Object, *ContextResource, error) {
+	st := llb.Image(bundle)
+	def, err := st.Marshal(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	res, err := c.Solve(ctx, client.SolveRequest{
 		Definition: def.ToPB(),
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to resolve oci bundle: %s", bundle)
+		return nil, nil, errors.Wrapf(err, "failed to resolve oci bundle: %s", bundle)
 	}
 
 	ref, err := res.SingleRef()
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to resolve oci bundle: %s", bundle)
+		return nil, nil, errors.Wrapf(err, "failed to resolve oci bundle: %s", bundle)
 	}
 
 	dt, err := ref.ReadFile(ctx, client.ReadRequest{
 		Filename: name,
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to resolve %s in oci bundle: %s", name, bundle)
+		return nil, nil, errors.Wrapf(err, "failed to resolve %s in oci bundle: %s", name, bundle)
 	}
 	decoder := k8scheme.Codecs.UniversalDeserializer()
 	obj, _, err := decoder.Decode(dt, nil, nil)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to resolve %s in oci bundle: %s", name, bundle)
+		return nil, nil, errors.Wrapf(err, "failed to resolve %s in oci bundle: %s", name, bundle)
 	}
-	return obj, nil
+	resource := &ContextResource{
+		Path:      bundle + "/" + name,
+		Data:      string(dt),
+		SourceMap: llb.NewSourceMap(&st, name, "YAML", dt),
+	}
+	// Bundles are often still v1beta1
+	obj, err = convertToV1(ctx, obj)
+	return obj, resource, err
 }
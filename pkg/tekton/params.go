@@ -0,0 +1,94 @@
+package tekton
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+)
+
+// paramTypes returns the type of each of the given params.
+func paramTypes(specs v1.ParamSpecs) map[string]v1.ParamType {
+	types := make(map[string]v1.ParamType, len(specs))
+	for _, spec := range specs {
+		// The type defaults to the one of the default value
+		spec.SetDefaults(context.Background())
+		types[spec.Name] = spec.Type
+	}
+	return types
+}
+
+// paramValue parses a param value given as a string, like tkn: array values
+// are separated by commas, object values are key:value pairs separated by
+// commas.
+func paramValue(t v1.ParamType, raw string) (v1.ParamValue, error) {
+	switch t {
+	case v1.ParamTypeArray:
+		return v1.ParamValue{Type: v1.ParamTypeArray, ArrayVal: strings.Split(raw, ",")}, nil
+	case v1.ParamTypeObject:
+		obj, err := parseObjectParam(raw)
+		if err != nil {
+			return v1.ParamValue{}, err
+		}
+		return v1.ParamValue{Type: v1.ParamTypeObject, ObjectVal: obj}, nil
+	default:
+		return v1.ParamValue{Type: v1.ParamTypeString, StringVal: raw}, nil
+	}
+}
+
+// parseObjectParam parses an object param value, as key:value pairs
+// separated by commas.
+func parseObjectParam(raw string) (map[string]string, error) {
+	obj := map[string]string{}
+	for _, field := range strings.Split(raw, ",") {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid object value %q, expected key:value", field)
+		}
+		obj[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return obj, nil
+}
+
+// overrideParams applies the param option values to the params of a run, of
+// a Task or Pipeline declaring specs. Values not declared are ignored, as
+// they may be meant for another run of the main file.
+func overrideParams(params v1.Params, specs v1.ParamSpecs, overrides map[string]string) (v1.Params, error) {
+	if len(overrides) == 0 {
+		return params, nil
+	}
+	types := paramTypes(specs)
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := append(v1.Params{}, params...)
+	for _, name := range names {
+		t, ok := types[name]
+		if !ok {
+			logrus.Warnf("param %s is not declared, ignoring its value", name)
+			continue
+		}
+		v, err := paramValue(t, overrides[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "param %s", name)
+		}
+		result = setParam(result, v1.Param{Name: name, Value: v})
+	}
+	return result, nil
+}
+
+// setParam replaces the param of the same name, or adds it.
+func setParam(params v1.Params, param v1.Param) v1.Params {
+	for i := range params {
+		if params[i].Name == param.Name {
+			params[i] = param
+			return params
+		}
+	}
+	return append(params, param)
+}
@@ -0,0 +1,43 @@
+package tekton
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+)
+
+func TestOverrideParams(t *testing.T) {
+	specs := v1.ParamSpecs{
+		{Name: "version"},
+		{Name: "flags", Type: v1.ParamTypeArray},
+		{Name: "labels", Default: &v1.ParamValue{Type: v1.ParamTypeObject, ObjectVal: map[string]string{"a": "b"}}},
+	}
+	params := v1.Params{
+		{Name: "version", Value: *v1.NewStructuredValues("1.0")},
+	}
+	got, err := overrideParams(params, specs, map[string]string{
+		"version": "2.0",
+		"flags":   "-v,-race",
+		"labels":  "app:test",
+		"unknown": "ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := v1.Params{
+		{Name: "version", Value: v1.ParamValue{Type: v1.ParamTypeString, StringVal: "2.0"}},
+		{Name: "flags", Value: v1.ParamValue{Type: v1.ParamTypeArray, ArrayVal: []string{"-v", "-race"}}},
+		{Name: "labels", Value: v1.ParamValue{Type: v1.ParamTypeObject, ObjectVal: map[string]string{"app": "test"}}},
+	}
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Errorf("params mismatch %s", diff.PrintWantGot(d))
+	}
+	if params[0].Value.StringVal != "1.0" {
+		t.Errorf("the run params should not be modified")
+	}
+	if _, err := overrideParams(params, specs, map[string]string{"labels": "invalid"}); err == nil {
+		t.Errorf("expected an error for an invalid object value")
+	}
+}
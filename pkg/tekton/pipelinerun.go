@@ -3,101 +3,105 @@ package tekton
 import (
 	"context"
 	"fmt"
-	"time"
+	"slices"
 
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
 	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
 	"github.com/tektoncd/pipeline/pkg/reconciler/pipelinerun/resources"
-	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
-type pipelineMountOptionFn func(string) mountOptionFn
-
-// PipelineRunToLLB converts a PipelineRun into a BuildKit LLB State.
-func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.State, error) {
+// PipelineRunToLLB converts a PipelineRun into a BuildKit LLB State, and its
+// Tasks with sidecars to run alongside them.
+func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.State, []TaskSidecars, error) {
 	pr := r.main
+	bindings, contexts, err := overrideWorkspaces(pr.Spec.Workspaces, config.FromContext(ctx).Workspaces)
+	if err != nil {
+		return llb.State{}, nil, err
+	}
+	pr.Spec.Workspaces = bindings
 	// Validation
-	if err := validatePipelineRun(ctx, pr); err != nil {
-		return llb.State{}, err
+	if err := validatePipelineRun(ctx, c, pr, r.locations[pr]); err != nil {
+		return llb.State{}, nil, err
+	}
+	// Credentials are per registry for the whole build, not per task: the
+	// ones of the TaskRunSpecs must not conflict (see credentials.Store).
+	pullSecrets := imagePullSecrets(ctx, pr.Spec.TaskRunTemplate.ServiceAccountName, pr.Spec.TaskRunTemplate.PodTemplate, r.serviceAccounts)
+	pullSecretsLoc := r.locations[pr].child("spec", "taskRunTemplate", "podTemplate", "imagePullSecrets")
+	if err := registerImagePullSecrets(ctx, c, pullSecretsLoc, pullSecrets, r.secrets); err != nil {
+		return llb.State{}, nil, err
+	}
+	for i, trs := range pr.Spec.TaskRunSpecs {
+		if trs.ServiceAccountName == "" && trs.PodTemplate == nil {
+			// Same as the TaskRunTemplate
+			continue
+		}
+		taskRunSpec := pr.GetTaskRunSpec(trs.PipelineTaskName)
+		pullSecrets := imagePullSecrets(ctx, taskRunSpec.ServiceAccountName, taskRunSpec.PodTemplate, r.serviceAccounts)
+		pullSecretsLoc := r.locations[pr].child("spec", "taskRunSpecs", i, "podTemplate", "imagePullSecrets")
+		if err := registerImagePullSecrets(ctx, c, pullSecretsLoc, pullSecrets, r.secrets); err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "TaskRunSpecs %s", trs.PipelineTaskName)
+		}
 	}
 
 	var ps *v1.PipelineSpec
 	var name string
+	var pipelineLoc location
 	if pr.Spec.PipelineSpec != nil {
 		ps = pr.Spec.PipelineSpec
 		name = "embedded"
+		pipelineLoc = r.locations[pr].child("spec", "pipelineSpec")
 		// FIXME: we need to support this
 		// } else if pr.Spec.PipelineRef != nil && pr.Spec.PipelineRef.Bundle != "" {
-		// 	resolvedPipeline, err := resolvePipelineInBundle(ctx, c, *pr.Spec.PipelineRef)
+		// 	resolvedPipeline, loc, err := resolvePipelineInBundle(ctx, c, *pr.Spec.PipelineRef)
 		// 	if err != nil {
-		// 		return llb.State{}, err
+		// 		return llb.State{}, nil, err
 		// 	}
 		// 	ps = &resolvedPipeline.Spec
+		// 	pipelineLoc = loc.child("spec")
 		// 	name = pr.Spec.PipelineRef.Name
 	} else if pr.Spec.PipelineRef != nil && pr.Spec.PipelineRef.Name != "" {
 		p, ok := r.pipelines[pr.Spec.PipelineRef.Name]
 		if !ok {
-			return llb.State{}, errors.Errorf("PipelineRef %s not found in context", pr.Spec.PipelineRef.Name)
+			return llb.State{}, nil, r.locations[pr].child("spec", "pipelineRef").wrapError(ctx, errors.Errorf("PipelineRef %s not found in context", pr.Spec.PipelineRef.Name))
 		}
 		p.SetDefaults(ctx)
+		warnDeprecations(ctx, c, r.locations[p], "Pipeline "+p.Name, p.Annotations)
+		pipelineLoc = r.locations[p].child("spec")
+		if err := validatePipeline(ctx, c, p.Spec, pipelineLoc); err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "pipeline %s", p.Name)
+		}
 		ps = &p.Spec
 		name = pr.Spec.PipelineRef.Name
 	}
 
+	if pr.Spec.Params, err = overrideParams(pr.Spec.Params, ps.Params, config.FromContext(ctx).Params); err != nil {
+		return llb.State{}, nil, err
+	}
+
 	// Interpolation
 	spec, err := applyPipelineRunSubstitution(ctx, pr, ps, name)
 	if err != nil {
-		return llb.State{}, errors.Wrap(err, "variable interpolation failed")
+		return llb.State{}, nil, errors.Wrap(err, "variable interpolation failed")
 	}
 
 	// Execution
-	pipelineWorkspaces := map[string]pipelineMountOptionFn{}
-	for _, w := range pr.Spec.Workspaces {
-		switch {
-		case w.ConfigMap != nil:
-			configmap, ok := r.configs[w.ConfigMap.Name]
-			if !ok {
-				return llb.State{}, errors.Errorf("Configmap %s not found in context", w.ConfigMap.Name)
-			}
-			configmapState, err := files.ConfigMap(configmap, w.ConfigMap)
-			if err != nil {
-				return llb.State{}, err
-			}
-			pipelineWorkspaces[w.Name] = func(name string) mountOptionFn {
-				return func(_ llb.State) llb.RunOption {
-					return llb.AddMount(name, configmapState, llb.SourcePath("/"), llb.Readonly)
-				}
-			}
-		case w.Secret != nil:
-			secret, ok := r.secrets[w.Secret.SecretName]
-			if !ok {
-				return llb.State{}, errors.Errorf("secret %s not found in context", w.Secret.SecretName)
-			}
-			secretState, err := files.Secret(secret, w.Secret)
-			if err != nil {
-				return llb.State{}, err
-			}
-			pipelineWorkspaces[w.Name] = func(name string) mountOptionFn {
-				return func(_ llb.State) llb.RunOption {
-					return llb.AddMount(name, secretState, llb.SourcePath("/"), llb.Readonly)
-				}
-			}
-		case w.EmptyDir != nil ||
-			w.VolumeClaimTemplate != nil ||
-			w.PersistentVolumeClaim != nil:
-			pipelineWorkspaces[w.Name] = func(name string) mountOptionFn {
-				return func(state llb.State) llb.RunOption {
-					return llb.AddMount(name, state, llb.AsPersistentCacheDir(pr.Name+"/"+w.Name, llb.CacheMountShared))
-				}
-			}
-		}
+	pipelineWorkspaces, err := bindWorkspaces(pr.Spec.Workspaces, pr.Name, r.configs, r.secrets)
+	if err != nil {
+		return llb.State{}, nil, err
 	}
+	if err := bindContextWorkspaces(c, pipelineWorkspaces, pr.Spec.Workspaces, contexts, pr.Name); err != nil {
+		return llb.State{}, nil, err
+	}
+	sidecars := []TaskSidecars{}
+	sidecarsAfter := map[string][]string{} // Tasks with sidecars a task runs after (or is)
 	tasks := map[string][]llb.State{}
-	skippedTasks := map[string]bool{} // Track tasks skipped due to WhenExpressions
-	for _, t := range spec.Tasks {
+	skippedTasks := map[string]bool{}   // Track tasks skipped due to WhenExpressions
+	validatedTasks := map[string]bool{} // Referenced Tasks are validated once
+	for i, t := range spec.Tasks {
 		// Evaluate WhenExpressions - skip task if conditions not met
 		if len(t.When) > 0 {
 			if !evaluateWhenExpressions(t.When) {
@@ -108,52 +112,82 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 
 		var ts v1.TaskSpec
 		var name string
+		var taskLoc location
 		if t.TaskRef != nil {
 			name = t.TaskRef.Name
 			// FIXME: we need to support this
 			// if t.TaskRef.Bundle != "" {
-			// 	resolvedTask, err := resolveTaskInBundle(ctx, c, *t.TaskRef)
+			// 	resolvedTask, loc, err := resolveTaskInBundle(ctx, c, *t.TaskRef)
 			// 	if err != nil {
-			// 		return llb.State{}, err
+			// 		return llb.State{}, nil, err
 			// 	}
 			// 	ts = resolvedTask.Spec
+			// 	taskLoc = loc.child("spec")
 			// } else {
 			task, ok := r.tasks[t.TaskRef.Name]
 			if !ok {
-				return llb.State{}, errors.Errorf("Taskref %s not found in context", t.TaskRef.Name)
+				return llb.State{}, nil, pipelineLoc.child("tasks", i, "taskRef").wrapError(ctx, errors.Errorf("Taskref %s not found in context", t.TaskRef.Name))
 			}
 			task.SetDefaults(ctx)
+			taskLoc = r.locations[task].child("spec")
+			if !validatedTasks[task.Name] {
+				warnDeprecations(ctx, c, r.locations[task], "Task "+task.Name, task.Annotations)
+				if err := validateTaskSpec(ctx, c, task.Spec, taskLoc); err != nil {
+					return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
+				}
+				validatedTasks[task.Name] = true
+			}
 			ts = task.Spec
 			// }
 		} else if t.TaskSpec != nil {
 			name = "embedded"
 			ts = t.TaskSpec.TaskSpec
+			taskLoc = pipelineLoc.child("tasks", i, "taskSpec")
+		}
+
+		ts, err = resolveStepActions(ctx, ts, r.stepActions)
+		if err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
 		}
 
+		taskRunSpec := pr.GetTaskRunSpec(t.Name)
+		boundWorkspaces, workspaceBindings := pipelineTaskBoundWorkspaces(t, pr.Spec.Workspaces, pipelineWorkspaces)
+		if err := validateCoschedule(ctx, workspaceBindings); err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
+		}
 		ts, err = applyTaskRunSubstitution(ctx, &v1.TaskRun{
 			Spec: v1.TaskRunSpec{
-				Params:   t.Params,
-				TaskSpec: &ts,
+				Params:             t.Params,
+				TaskSpec:           &ts,
+				Workspaces:         workspaceBindings,
+				ServiceAccountName: taskRunSpec.ServiceAccountName,
 			},
 		}, &ts, name)
 		if err != nil {
-			return llb.State{}, errors.Wrapf(err, "variable interpolation failed for %s", t.Name)
+			return llb.State{}, nil, errors.Wrapf(err, "variable interpolation failed for %s", t.Name)
+		}
+		ts, err = applyTaskRunOverrides(ts, taskRunSpec.StepSpecs, taskRunSpec.SidecarSpecs, taskRunSpec.ComputeResources)
+		if err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
 		}
 
-		taskWorkspaces := []mountOptionFn{}
-		for _, w := range t.Workspaces {
-			fn := pipelineWorkspaces[w.Workspace]
-			taskWorkspaces = append(taskWorkspaces, fn("/workspace/"+w.Name))
+		taskWorkspaces, err := taskWorkspaceMounts(ts.Workspaces, boundWorkspaces)
+		if err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
 		}
-		// Get task timeout as time.Duration pointer
-		var taskTimeout *time.Duration
-		if t.Timeout != nil {
-			d := t.Timeout.Duration
-			taskTimeout = &d
+		// TaskRunSpecs timeout takes precedence over the PipelineTask one,
+		// falling back to the PipelineRun timeouts (defaulted with
+		// default-timeout-minutes).
+		taskTimeout := firstTimeout(taskRunSpec.Timeout, t.Timeout, pr.Spec.Timeouts.Tasks, pr.Spec.Timeouts.Pipeline)
+		meta := pipelineTaskPodMetadata(pr, t, taskRunSpec)
+		taskName := pipelineTaskName(pr, t.Name)
+		steps, err := taskSpecToPSteps(ctx, c, ts, taskLoc, taskName, meta, taskWorkspaces, taskTimeout, r.configs, r.secrets)
+		if err != nil {
+			return llb.State{}, nil, errors.Wrap(err, "couldn't translate TaskSpec to llb")
 		}
-		steps, err := taskSpecToPSteps(ctx, c, ts, t.Name, taskWorkspaces, taskTimeout, r.configs, r.secrets)
+		taskSidecars, err := taskSpecToSidecars(ctx, c, ts, taskLoc, taskName, meta, r.configs, r.secrets)
 		if err != nil {
-			return llb.State{}, errors.Wrap(err, "couldn't translate TaskSpec to llb")
+			return llb.State{}, nil, errors.Wrap(err, "couldn't translate sidecars")
 		}
 		mounts := []llb.RunOption{}
 		if len(t.RunAfter) > 0 {
@@ -168,20 +202,34 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 				// Mount previous task's results cache to access its results
 				targetMount := fmt.Sprintf("/tekton/from-task/%s", a)
 				mounts = append(mounts,
-					llb.AddMount(targetMount, llb.Scratch(), llb.AsPersistentCacheDir(a+"/results", llb.CacheMountShared), llb.Readonly),
+					llb.AddMount(targetMount, llb.Scratch(), llb.AsPersistentCacheDir(pipelineTaskName(pr, a)+"/results", llb.CacheMountShared), llb.Readonly),
 				)
 			}
 		}
 		resultState := llb.Scratch()
 		stepStates, err := pstepToState(c, steps, resultState, mounts)
 		if err != nil {
-			return llb.State{}, err
+			return llb.State{}, nil, err
 		}
 		tasks[t.Name] = stepStates
+		after := []string{}
+		for _, a := range t.RunAfter {
+			after = appendMissing(after, sidecarsAfter[a]...)
+		}
+		sidecarsAfter[t.Name] = after
+		if len(taskSidecars) > 0 {
+			sidecars = append(sidecars, TaskSidecars{name: taskName, state: stepStates[len(stepStates)-1], sidecars: taskSidecars, after: after})
+			sidecarsAfter[t.Name] = appendMissing(append([]string{}, after...), taskName)
+		}
 	}
 
 	// Process Finally blocks - they run after ALL regular tasks complete
 	finallyTasks := map[string][]llb.State{}
+	// Finally tasks run after all the tasks (with sidecars)
+	finallyAfter := []string{}
+	for _, t := range spec.Tasks {
+		finallyAfter = appendMissing(finallyAfter, sidecarsAfter[t.Name]...)
+	}
 	if len(spec.Finally) > 0 {
 		// Build mounts from all regular tasks to ensure Finally runs after them
 		finallyMounts := []llb.RunOption{}
@@ -195,60 +243,90 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 				// Mount previous task's results cache to access its results
 				targetMount := fmt.Sprintf("/tekton/from-task/%s", taskName)
 				finallyMounts = append(finallyMounts,
-					llb.AddMount(targetMount, llb.Scratch(), llb.AsPersistentCacheDir(taskName+"/results", llb.CacheMountShared), llb.Readonly),
+					llb.AddMount(targetMount, llb.Scratch(), llb.AsPersistentCacheDir(pipelineTaskName(pr, taskName)+"/results", llb.CacheMountShared), llb.Readonly),
 				)
 			}
 		}
 
-		for _, t := range spec.Finally {
+		for i, t := range spec.Finally {
 			var ts v1.TaskSpec
 			var name string
+			var taskLoc location
 			if t.TaskRef != nil {
 				name = t.TaskRef.Name
 				task, ok := r.tasks[t.TaskRef.Name]
 				if !ok {
-					return llb.State{}, errors.Errorf("Finally Taskref %s not found in context", t.TaskRef.Name)
+					return llb.State{}, nil, pipelineLoc.child("finally", i, "taskRef").wrapError(ctx, errors.Errorf("Finally Taskref %s not found in context", t.TaskRef.Name))
 				}
 				task.SetDefaults(ctx)
+				taskLoc = r.locations[task].child("spec")
+				if !validatedTasks[task.Name] {
+					warnDeprecations(ctx, c, r.locations[task], "Task "+task.Name, task.Annotations)
+					if err := validateTaskSpec(ctx, c, task.Spec, taskLoc); err != nil {
+						return llb.State{}, nil, errors.Wrapf(err, "finally task %s", t.Name)
+					}
+					validatedTasks[task.Name] = true
+				}
 				ts = task.Spec
 			} else if t.TaskSpec != nil {
 				name = "embedded"
 				ts = t.TaskSpec.TaskSpec
+				taskLoc = pipelineLoc.child("finally", i, "taskSpec")
 			}
 
+			ts, err = resolveStepActions(ctx, ts, r.stepActions)
+			if err != nil {
+				return llb.State{}, nil, errors.Wrapf(err, "finally task %s", t.Name)
+			}
+
+			taskRunSpec := pr.GetTaskRunSpec(t.Name)
+			boundWorkspaces, workspaceBindings := pipelineTaskBoundWorkspaces(t, pr.Spec.Workspaces, pipelineWorkspaces)
+			if err := validateCoschedule(ctx, workspaceBindings); err != nil {
+				return llb.State{}, nil, errors.Wrapf(err, "finally task %s", t.Name)
+			}
 			ts, err = applyTaskRunSubstitution(ctx, &v1.TaskRun{
 				Spec: v1.TaskRunSpec{
-					Params:   t.Params,
-					TaskSpec: &ts,
+					Params:             t.Params,
+					TaskSpec:           &ts,
+					Workspaces:         workspaceBindings,
+					ServiceAccountName: taskRunSpec.ServiceAccountName,
 				},
 			}, &ts, name)
 			if err != nil {
-				return llb.State{}, errors.Wrapf(err, "variable interpolation failed for finally task %s", t.Name)
+				return llb.State{}, nil, errors.Wrapf(err, "variable interpolation failed for finally task %s", t.Name)
+			}
+			ts, err = applyTaskRunOverrides(ts, taskRunSpec.StepSpecs, taskRunSpec.SidecarSpecs, taskRunSpec.ComputeResources)
+			if err != nil {
+				return llb.State{}, nil, errors.Wrapf(err, "finally task %s", t.Name)
 			}
 
-			taskWorkspaces := []mountOptionFn{}
-			for _, w := range t.Workspaces {
-				fn := pipelineWorkspaces[w.Workspace]
-				if fn != nil {
-					taskWorkspaces = append(taskWorkspaces, fn("/workspace/"+w.Name))
-				}
+			taskWorkspaces, err := taskWorkspaceMounts(ts.Workspaces, boundWorkspaces)
+			if err != nil {
+				return llb.State{}, nil, errors.Wrapf(err, "finally task %s", t.Name)
 			}
-			// Get task timeout as time.Duration pointer
-			var taskTimeout *time.Duration
-			if t.Timeout != nil {
-				d := t.Timeout.Duration
-				taskTimeout = &d
+			// TaskRunSpecs timeout takes precedence over the PipelineTask one,
+			// falling back to the PipelineRun timeouts (defaulted with
+			// default-timeout-minutes).
+			taskTimeout := firstTimeout(taskRunSpec.Timeout, t.Timeout, pr.Spec.Timeouts.Finally, pr.Spec.Timeouts.Pipeline)
+			meta := pipelineTaskPodMetadata(pr, t, taskRunSpec)
+			taskName := pipelineTaskName(pr, "finally/"+t.Name)
+			steps, err := taskSpecToPSteps(ctx, c, ts, taskLoc, taskName, meta, taskWorkspaces, taskTimeout, r.configs, r.secrets)
+			if err != nil {
+				return llb.State{}, nil, errors.Wrap(err, "couldn't translate Finally TaskSpec to llb")
 			}
-			steps, err := taskSpecToPSteps(ctx, c, ts, "finally/"+t.Name, taskWorkspaces, taskTimeout, r.configs, r.secrets)
+			taskSidecars, err := taskSpecToSidecars(ctx, c, ts, taskLoc, taskName, meta, r.configs, r.secrets)
 			if err != nil {
-				return llb.State{}, errors.Wrap(err, "couldn't translate Finally TaskSpec to llb")
+				return llb.State{}, nil, errors.Wrap(err, "couldn't translate sidecars")
 			}
 			resultState := llb.Scratch()
 			stepStates, err := pstepToState(c, steps, resultState, finallyMounts)
 			if err != nil {
-				return llb.State{}, err
+				return llb.State{}, nil, err
 			}
 			finallyTasks[t.Name] = stepStates
+			if len(taskSidecars) > 0 {
+				sidecars = append(sidecars, TaskSidecars{name: taskName, state: stepStates[len(stepStates)-1], sidecars: taskSidecars, after: finallyAfter})
+			}
 		}
 	}
 
@@ -262,7 +340,7 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 			allStates = append(allStates, t[len(t)-1])
 			// Mount the results cache for this task
 			resultCacheMounts = append(resultCacheMounts,
-				llb.AddMount(fmt.Sprintf("/task/%s", n), llb.Scratch(), llb.AsPersistentCacheDir(n+"/results", llb.CacheMountShared), llb.Readonly),
+				llb.AddMount(fmt.Sprintf("/task/%s", n), llb.Scratch(), llb.AsPersistentCacheDir(pipelineTaskName(pr, n)+"/results", llb.CacheMountShared), llb.Readonly),
 			)
 		}
 	}
@@ -270,7 +348,7 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 		if len(t) > 0 {
 			allStates = append(allStates, t[len(t)-1])
 			resultCacheMounts = append(resultCacheMounts,
-				llb.AddMount(fmt.Sprintf("/task/finally/%s", n), llb.Scratch(), llb.AsPersistentCacheDir("finally/"+n+"/results", llb.CacheMountShared), llb.Readonly),
+				llb.AddMount(fmt.Sprintf("/task/finally/%s", n), llb.Scratch(), llb.AsPersistentCacheDir(pipelineTaskName(pr, "finally/"+n)+"/results", llb.CacheMountShared), llb.Readonly),
 			)
 		}
 	}
@@ -291,7 +369,24 @@ func PipelineRunToLLB(ctx context.Context, c client.Client, r PipelineRun) (llb.
 
 	return llb.Image("alpine:latest", llb.WithMetaResolver(c)).
 		Run(runOpts...).
-		Root(), nil
+		Root(), sidecars, nil
+}
+
+// appendMissing appends the given names to names, skipping the ones already in.
+func appendMissing(names []string, others ...string) []string {
+	for _, o := range others {
+		if !slices.Contains(names, o) {
+			names = append(names, o)
+		}
+	}
+	return names
+}
+
+// pipelineTaskName returns the name of a PipelineTask (or finally/<name>) of
+// a run, keying its caches (e.g. results): the runs executed together may
+// use the same task names.
+func pipelineTaskName(pr *v1.PipelineRun, name string) string {
+	return pr.Name + "/" + name
 }
 
 func applyPipelineRunSubstitution(ctx context.Context, pr *v1.PipelineRun, ps *v1.PipelineSpec, pipelineName string) (v1.PipelineSpec, error) {
@@ -310,60 +405,66 @@ func applyPipelineRunSubstitution(ctx context.Context, pr *v1.PipelineRun, ps *v
 	return *ps, nil
 }
 
-func validatePipelineRun(ctx context.Context, pr *v1.PipelineRun) error {
+func validatePipelineRun(ctx context.Context, c client.Client, pr *v1.PipelineRun, loc location) error {
 	if pr.Name == "" && pr.GenerateName != "" {
 		pr.Name = pr.GenerateName + "generated"
 	}
 	pr.SetDefaults(ctx)
 	if err := pr.Validate(ctx); err != nil {
-		return errors.Wrapf(err, "validation failed for PipelineRun %s", pr.Name)
+		return loc.wrapError(ctx, errors.Wrapf(err, "validation failed for PipelineRun %s", pr.Name))
 	}
-	// SilentlyIgnore ServiceAccountName
-	// SilentlyIgnore ServiceAccountNames
-	// SilentlyIgnore Status
-	// SilentryIgnore Timeouts
-	// if pr.Spec.Timeouts != nil {
-	// 	return errors.New("Timeouts are not supported")
-	// }
-	// We might be able to silently ignore
-	if pr.Spec.TaskRunTemplate.PodTemplate != nil {
-		return errors.New("PodTemplate are not supported")
+	// ServiceAccountName is used to look up imagePullSecrets
+	// Timeouts are applied to the steps of the tasks without their own timeout
+	warnIgnoredFields(ctx, c, loc.child("spec"), "PipelineRun "+pr.Name, []ignoredField{
+		{"status", pr.Spec.Status != ""},
+	})
+	// imagePullSecrets, hostAliases, env, securityContext (runAsUser and
+	// runAsGroup) and dnsConfig are supported, scheduling fields are ignored
+	warnDeprecations(ctx, c, loc, "PipelineRun "+pr.Name, pr.Annotations)
+	tplLoc := loc.child("spec", "taskRunTemplate", "podTemplate")
+	if err := validatePodTemplate(ctx, c, pr.Spec.TaskRunTemplate.PodTemplate, tplLoc); err != nil {
+		return tplLoc.wrapError(ctx, err)
 	}
-	if pr.Spec.TaskRunSpecs != nil {
-		return errors.New("TaskRunSpecs are not supported")
+	// TaskRunSpecs are applied to their PipelineTask
+	for i, trs := range pr.Spec.TaskRunSpecs {
+		tplLoc := loc.child("spec", "taskRunSpecs", i, "podTemplate")
+		if err := validatePodTemplate(ctx, c, trs.PodTemplate, tplLoc); err != nil {
+			return tplLoc.wrapError(ctx, errors.Wrapf(err, "TaskRunSpecs %s", trs.PipelineTaskName))
+		}
 	}
 	if pr.Spec.PipelineSpec != nil {
-		return validatePipeline(ctx, *pr.Spec.PipelineSpec)
+		return validatePipeline(ctx, c, *pr.Spec.PipelineSpec, loc.child("spec", "pipelineSpec"))
 	}
 	return nil
 }
 
-func validatePipeline(ctx context.Context, p v1.PipelineSpec) error {
+func validatePipeline(ctx context.Context, c client.Client, p v1.PipelineSpec, loc location) error {
 	// Finally blocks are now supported
 	// WhenExpressions are now supported (for regular tasks only, not finally)
-	for _, pt := range p.Finally {
+	for i, pt := range p.Finally {
 		if len(pt.When) > 0 {
-			return errors.Errorf("Finally task %s: WhenExpressions not supported in finally blocks", pt.Name)
+			return loc.child("finally", i, "when").wrapError(ctx, errors.Errorf("Finally task %s: WhenExpressions not supported in finally blocks", pt.Name))
 		}
 		// Task Timeout is now supported (applied to each step)
+		warnIgnoredPipelineTaskFields(ctx, c, pt, loc.child("finally", i), "finally task "+pt.Name)
 		if pt.TaskSpec != nil {
 			if !isTektonTask(pt.TaskSpec.TypeMeta) {
-				return errors.Errorf("Finally task %s: Custom task not supported", pt.Name)
+				return loc.child("finally", i, "taskSpec").wrapError(ctx, errors.Errorf("Finally task %s: Custom task not supported", pt.Name))
 			}
-			if err := validateTaskSpec(ctx, pt.TaskSpec.TaskSpec); err != nil {
+			if err := validateTaskSpec(ctx, c, pt.TaskSpec.TaskSpec, loc.child("finally", i, "taskSpec")); err != nil {
 				return err
 			}
 		}
 	}
-	for _, pt := range p.Tasks {
+	for i, pt := range p.Tasks {
 		// WhenExpressions are now supported - they are evaluated at LLB build time
-		// Silently ignore Retries
 		// Task Timeout is now supported (applied to each step)
+		warnIgnoredPipelineTaskFields(ctx, c, pt, loc.child("tasks", i), "task "+pt.Name)
 		if pt.TaskSpec != nil {
 			if !isTektonTask(pt.TaskSpec.TypeMeta) {
-				return errors.Errorf("Task %s: Custom task not supported", pt.Name)
+				return loc.child("tasks", i, "taskSpec").wrapError(ctx, errors.Errorf("Task %s: Custom task not supported", pt.Name))
 			}
-			if err := validateTaskSpec(ctx, pt.TaskSpec.TaskSpec); err != nil {
+			if err := validateTaskSpec(ctx, c, pt.TaskSpec.TaskSpec, loc.child("tasks", i, "taskSpec")); err != nil {
 				return err
 			}
 		}
@@ -371,6 +472,19 @@ func validatePipeline(ctx context.Context, p v1.PipelineSpec) error {
 	return nil
 }
 
+// warnIgnoredPipelineTaskFields reports the fields of a PipelineTask that are
+// ignored: tasks run once (no retries nor matrix) and any failure fails the
+// run.
+func warnIgnoredPipelineTaskFields(ctx context.Context, c client.Client, pt v1.PipelineTask, loc location, what string) {
+	warnIgnoredFields(ctx, c, loc, what, []ignoredField{
+		{"retries", pt.Retries > 0},
+		{"matrix", pt.Matrix != nil},
+		{"onError", pt.OnError != ""},
+		{"pipelineRef", pt.PipelineRef != nil},
+		{"pipelineSpec", pt.PipelineSpec != nil},
+	})
+}
+
 func isTektonTask(typeMeta runtime.TypeMeta) bool {
 	return (typeMeta.APIVersion == "" && typeMeta.Kind == "") ||
 		(typeMeta.APIVersion == "tekton.dev/v1" && typeMeta.Kind == "Task")
@@ -4,10 +4,17 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
 	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -42,7 +49,7 @@ func TestValidatePipeline_WithFinally(t *testing.T) {
 		}},
 	}
 
-	err := validatePipeline(ctx, spec)
+	err := validatePipeline(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validatePipeline() with Finally should not error, got: %v", err)
 	}
@@ -66,7 +73,7 @@ func TestValidatePipeline_WithoutFinally(t *testing.T) {
 		}},
 	}
 
-	err := validatePipeline(ctx, spec)
+	err := validatePipeline(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validatePipeline() without Finally should not error, got: %v", err)
 	}
@@ -116,7 +123,7 @@ func TestPipelineRunToLLB_WithFinally(t *testing.T) {
 	}
 
 	// This should not error - Finally blocks are now supported
-	_, err := PipelineRunToLLB(ctx, nil, pipelineRun)
+	_, _, err := PipelineRunToLLB(ctx, nil, pipelineRun)
 	if err != nil {
 		t.Errorf("PipelineRunToLLB() with Finally should not error, got: %v", err)
 	}
@@ -161,7 +168,7 @@ func TestValidatePipeline_WithWhenExpressions(t *testing.T) {
 		}},
 	}
 
-	err := validatePipeline(ctx, spec)
+	err := validatePipeline(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validatePipeline() with WhenExpressions should not error, got: %v", err)
 	}
@@ -222,7 +229,7 @@ func TestPipelineRunToLLB_WithWhenExpressions(t *testing.T) {
 	}
 
 	// This should not error - WhenExpressions are now supported
-	_, err := PipelineRunToLLB(ctx, nil, pipelineRun)
+	_, _, err := PipelineRunToLLB(ctx, nil, pipelineRun)
 	if err != nil {
 		t.Errorf("PipelineRunToLLB() with WhenExpressions should not error, got: %v", err)
 	}
@@ -242,7 +249,7 @@ func TestValidateTaskSpec_WithStepTimeout(t *testing.T) {
 		}},
 	}
 
-	err := validateTaskSpec(ctx, spec)
+	err := validateTaskSpec(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validateTaskSpec() with Step Timeout should not error, got: %v", err)
 	}
@@ -269,7 +276,7 @@ func TestValidatePipeline_WithTaskTimeout(t *testing.T) {
 		}},
 	}
 
-	err := validatePipeline(ctx, spec)
+	err := validatePipeline(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validatePipeline() with Task Timeout should not error, got: %v", err)
 	}
@@ -294,12 +301,225 @@ func TestValidateTaskSpec_WithEnvFrom(t *testing.T) {
 		}},
 	}
 
-	err := validateTaskSpec(ctx, spec)
+	err := validateTaskSpec(ctx, &fakeClient{}, spec, location{})
 	if err != nil {
 		t.Errorf("validateTaskSpec() with EnvFrom should not error, got: %v", err)
 	}
 }
 
+func TestValidatePipelineRun_TaskRunSpecs(t *testing.T) {
+	ctx := context.Background()
+	newPipelineRun := func(tpl *pod.Template) *v1.PipelineRun {
+		return &v1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: "run"},
+			Spec: v1.PipelineRunSpec{
+				PipelineSpec: &v1.PipelineSpec{
+					Tasks: []v1.PipelineTask{{
+						Name: "build",
+						TaskSpec: &v1.EmbeddedTask{TaskSpec: v1.TaskSpec{
+							Steps: []v1.Step{{Name: "step1", Image: "alpine", Script: "true"}},
+						}},
+					}},
+				},
+				TaskRunSpecs: []v1.PipelineTaskRunSpec{{
+					PipelineTaskName:   "build",
+					ServiceAccountName: "builder",
+					PodTemplate:        tpl,
+				}},
+			},
+		}
+	}
+
+	if err := validatePipelineRun(ctx, &fakeClient{}, newPipelineRun(&pod.Template{
+		Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+	}), location{}); err != nil {
+		t.Errorf("validatePipelineRun() with TaskRunSpecs should not error, got: %v", err)
+	}
+	if err := validatePipelineRun(ctx, &fakeClient{}, newPipelineRun(&pod.Template{
+		SchedulerName: "custom",
+	}), location{}); err == nil {
+		t.Error("validatePipelineRun() with an unsupported TaskRunSpecs podTemplate should error")
+	}
+}
+
+func TestPipelineTaskPodMetadata_TaskRunSpecs(t *testing.T) {
+	pr := &v1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "run",
+			Labels: map[string]string{"app": "demo", "tier": "pipeline"},
+		},
+		Spec: v1.PipelineRunSpec{
+			TaskRunTemplate: v1.PipelineTaskRunTemplate{
+				ServiceAccountName: "default-sa",
+				PodTemplate: &pod.Template{
+					Env: []corev1.EnvVar{{Name: "FROM", Value: "pipelinerun"}},
+				},
+			},
+			TaskRunSpecs: []v1.PipelineTaskRunSpec{{
+				PipelineTaskName:   "build",
+				ServiceAccountName: "builder",
+				PodTemplate: &pod.Template{
+					Env: []corev1.EnvVar{{Name: "FROM", Value: "taskrunspecs"}},
+				},
+				Metadata: &v1.PipelineTaskMetadata{
+					Labels:      map[string]string{"tier": "build"},
+					Annotations: map[string]string{"note": "build"},
+				},
+			}},
+		},
+	}
+
+	build := pipelineTaskPodMetadata(pr, v1.PipelineTask{Name: "build"}, pr.GetTaskRunSpec("build"))
+	if build.serviceAccountName != "builder" {
+		t.Errorf("expected serviceAccountName builder, got %q", build.serviceAccountName)
+	}
+	if diff := cmp.Diff([]corev1.EnvVar{{Name: "FROM", Value: "taskrunspecs"}}, build.template.Env); diff != "" {
+		t.Errorf("podTemplate env mismatch (-want +got):\n%s", diff)
+	}
+	if build.labels["tier"] != "build" || build.labels["app"] != "demo" {
+		t.Errorf("unexpected labels %v", build.labels)
+	}
+	if build.annotations["note"] != "build" {
+		t.Errorf("unexpected annotations %v", build.annotations)
+	}
+
+	test := pipelineTaskPodMetadata(pr, v1.PipelineTask{Name: "test"}, pr.GetTaskRunSpec("test"))
+	if test.serviceAccountName != "default-sa" {
+		t.Errorf("expected serviceAccountName default-sa, got %q", test.serviceAccountName)
+	}
+	if test.labels["tier"] != "pipeline" {
+		t.Errorf("unexpected labels %v", test.labels)
+	}
+}
+
+func TestApplyTaskRunOverrides(t *testing.T) {
+	ts := v1.TaskSpec{
+		Steps:    []v1.Step{{Name: "build", Image: "alpine"}, {Name: "test", Image: "alpine"}},
+		Sidecars: []v1.Sidecar{{Name: "db", Image: "postgres"}},
+	}
+	stepLimits := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+	}
+	sidecarLimits := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+	}
+
+	got, err := applyTaskRunOverrides(ts,
+		[]v1.TaskRunStepSpec{{Name: "build", ComputeResources: stepLimits}},
+		[]v1.TaskRunSidecarSpec{{Name: "db", ComputeResources: sidecarLimits}},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(stepLimits, got.Steps[0].ComputeResources); diff != "" {
+		t.Errorf("step build resources mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(corev1.ResourceRequirements{}, got.Steps[1].ComputeResources); diff != "" {
+		t.Errorf("step test resources mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(sidecarLimits, got.Sidecars[0].ComputeResources); diff != "" {
+		t.Errorf("sidecar db resources mismatch (-want +got):\n%s", diff)
+	}
+
+	// Task-level computeResources apply to every step
+	taskLimits := &corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+	}
+	got, err = applyTaskRunOverrides(ts, nil, nil, taskLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range got.Steps {
+		if diff := cmp.Diff(*taskLimits, step.ComputeResources); diff != "" {
+			t.Errorf("step %s resources mismatch (-want +got):\n%s", step.Name, diff)
+		}
+	}
+}
+
+const sameTaskNamesRuns = `apiVersion: tekton.dev/v1
+kind: PipelineRun
+metadata:
+  name: one
+spec:
+  pipelineSpec:
+    tasks:
+    - name: build
+      taskSpec:
+        steps:
+        - name: build
+          image: alpine
+          script: echo one
+    finally:
+    - name: report
+      taskSpec:
+        steps:
+        - name: report
+          image: alpine
+          script: echo one
+---
+apiVersion: tekton.dev/v1
+kind: PipelineRun
+metadata:
+  name: two
+spec:
+  pipelineSpec:
+    tasks:
+    - name: build
+      taskSpec:
+        steps:
+        - name: build
+          image: alpine
+          script: echo two
+    finally:
+    - name: report
+      taskSpec:
+        steps:
+        - name: report
+          image: alpine
+          script: echo two
+`
+
+func TestTektonToLLB_RunCaches(t *testing.T) {
+	cfg := &config.Config{EntrypointImage: config.DefaultEntrypointImage(), Run: config.AllRuns}
+	ctx := cfg.ToContext(context.Background())
+	st, _, err := TektonToLLB(&fakeClient{})(ctx, ContextResource{Path: "runs.yaml", Data: sameTaskNamesRuns}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range op.GetExec().GetMounts() {
+			if m.MountType == pb.MountType_CACHE {
+				ids[m.CacheOpt.ID] = true
+			}
+		}
+	}
+	got := []string{}
+	for id := range ids {
+		got = append(got, id)
+	}
+	sort.Strings(got)
+	// The runs use the same task names, but not the same caches
+	expected := []string{
+		"one/build/results",
+		"one/finally/report/results",
+		"two/build/results",
+		"two/finally/report/results",
+	}
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Errorf("cache IDs mismatch %s", diff.PrintWantGot(d))
+	}
+}
+
 // Suppress unused import warnings
 var _ = fmt.Sprintf
 var _ = os.Stderr
@@ -0,0 +1,131 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// validatePodTemplate makes sure only supported fields are set on the
+// PodTemplate. Scheduling-only fields are ignored, with a warning reported at
+// loc, the location of the PodTemplate.
+func validatePodTemplate(ctx context.Context, c client.Client, tpl *pod.Template, loc location) error {
+	if tpl == nil {
+		return nil
+	}
+	t := tpl.DeepCopy()
+	// Supported fields
+	t.ImagePullSecrets = nil
+	t.HostAliases = nil
+	t.Env = nil
+	t.DNSConfig = nil
+	if t.SecurityContext != nil {
+		sc := t.SecurityContext.DeepCopy()
+		sc.RunAsUser = nil
+		sc.RunAsGroup = nil
+		if !equality.Semantic.DeepEqual(sc, &corev1.PodSecurityContext{}) {
+			warn(ctx, c, loc.child("securityContext"), "PodTemplate: only securityContext.runAsUser and runAsGroup are supported, ignoring the other fields")
+		}
+		t.SecurityContext = nil
+	}
+	if t.DNSPolicy != nil {
+		if *t.DNSPolicy != corev1.DNSNone {
+			warn(ctx, c, loc.child("dnsPolicy"), "PodTemplate: dnsPolicy %s is ignored", *t.DNSPolicy)
+		}
+		t.DNSPolicy = nil
+	}
+	// Scheduling-only fields, meaningless without a cluster
+	warnIgnoredFields(ctx, c, loc, "PodTemplate", []ignoredField{
+		{"nodeSelector", len(t.NodeSelector) > 0},
+		{"tolerations", len(t.Tolerations) > 0},
+		{"affinity", t.Affinity != nil},
+		{"topologySpreadConstraints", len(t.TopologySpreadConstraints) > 0},
+	})
+	t.NodeSelector = nil
+	t.Tolerations = nil
+	t.Affinity = nil
+	t.TopologySpreadConstraints = nil
+	if !t.Equals(&pod.Template{}) {
+		return errors.New("PodTemplate not supported (except imagePullSecrets, hostAliases, env, securityContext, dnsConfig and scheduling fields)")
+	}
+	return nil
+}
+
+// podTemplateRunOptions returns the run options of a step from the PodTemplate
+// of its run: hostAliases and dnsConfig. The user and group to run as are
+// merged with the step securityContext (see containerUser).
+func podTemplateRunOptions(tpl *pod.Template) ([]llb.RunOption, error) {
+	if tpl == nil {
+		return nil, nil
+	}
+	opts := []llb.RunOption{}
+	for _, alias := range tpl.HostAliases {
+		ip := net.ParseIP(alias.IP)
+		if ip == nil {
+			return nil, errors.Errorf("PodTemplate: invalid hostAliases IP %q", alias.IP)
+		}
+		for _, hostname := range alias.Hostnames {
+			opts = append(opts, llb.AddExtraHost(hostname, ip))
+		}
+	}
+	if tpl.DNSConfig != nil {
+		st := llb.Scratch().File(
+			llb.Mkfile("/resolv.conf", 0644, []byte(resolvConf(tpl.DNSConfig))),
+			llb.WithCustomName("[tekton] preparing resolv.conf"),
+		)
+		opts = append(opts, llb.AddMount("/etc/resolv.conf", st, llb.SourcePath("/resolv.conf"), llb.Readonly))
+	}
+	return opts, nil
+}
+
+// resolvConf returns the content of the resolv.conf file of the given
+// dnsConfig. As there is no cluster DNS, only the given nameservers, searches
+// and options are used (like with the None dnsPolicy).
+func resolvConf(cfg *corev1.PodDNSConfig) string {
+	var b strings.Builder
+	for _, ns := range cfg.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(cfg.Searches) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(cfg.Searches, " "))
+	}
+	options := []string{}
+	for _, o := range cfg.Options {
+		if o.Value != nil {
+			options = append(options, o.Name+":"+*o.Value)
+		} else {
+			options = append(options, o.Name)
+		}
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(options, " "))
+	}
+	return b.String()
+}
+
+// podTemplateEnv returns the env of a step merged with the env of the
+// PodTemplate, the step env taking precedence.
+func podTemplateEnv(tpl *pod.Template, env []corev1.EnvVar) []corev1.EnvVar {
+	if tpl == nil || len(tpl.Env) == 0 {
+		return env
+	}
+	defined := make(map[string]bool, len(env))
+	for _, e := range env {
+		defined[e.Name] = true
+	}
+	merged := []corev1.EnvVar{}
+	for _, e := range tpl.Env {
+		if !defined[e.Name] {
+			merged = append(merged, e)
+		}
+	}
+	return append(merged, env...)
+}
@@ -0,0 +1,134 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidatePodTemplate(t *testing.T) {
+	ctx := context.Background()
+	user := int64(1000)
+	if err := validatePodTemplate(ctx, &fakeClient{}, nil, location{}); err != nil {
+		t.Errorf("validatePodTemplate() with no PodTemplate should not error, got: %v", err)
+	}
+	if err := validatePodTemplate(ctx, &fakeClient{}, &pod.Template{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "regcred"}},
+	}, location{}); err != nil {
+		t.Errorf("validatePodTemplate() with imagePullSecrets should not error, got: %v", err)
+	}
+	c := &fakeClient{}
+	if err := validatePodTemplate(ctx, c, &pod.Template{
+		HostAliases:     []corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"registry.local"}}},
+		Env:             []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+		SecurityContext: &corev1.PodSecurityContext{RunAsUser: &user},
+		DNSConfig:       &corev1.PodDNSConfig{Nameservers: []string{"1.1.1.1"}},
+		NodeSelector:    map[string]string{"kubernetes.io/os": "linux"},
+		Tolerations:     []corev1.Toleration{{Key: "foo", Operator: corev1.TolerationOpExists}},
+		Affinity:        &corev1.Affinity{},
+	}, location{}); err != nil {
+		t.Errorf("validatePodTemplate() with supported and scheduling fields should not error, got: %v", err)
+	}
+	expected := []string{
+		"PodTemplate: nodeSelector is ignored",
+		"PodTemplate: tolerations is ignored",
+		"PodTemplate: affinity is ignored",
+	}
+	if d := cmp.Diff(expected, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+	if err := validatePodTemplate(ctx, &fakeClient{}, &pod.Template{
+		SchedulerName: "foo",
+	}, location{}); err == nil {
+		t.Errorf("validatePodTemplate() with schedulerName should error")
+	}
+	if err := validatePodTemplate(ctx, &fakeClient{}, &pod.Template{
+		HostNetwork: true,
+	}, location{}); err == nil {
+		t.Errorf("validatePodTemplate() with hostNetwork should error")
+	}
+}
+
+func TestResolvConf(t *testing.T) {
+	ndots := "2"
+	got := resolvConf(&corev1.PodDNSConfig{
+		Nameservers: []string{"1.1.1.1", "8.8.8.8"},
+		Searches:    []string{"ns1.svc.cluster.local", "my.dns.search.suffix"},
+		Options:     []corev1.PodDNSConfigOption{{Name: "ndots", Value: &ndots}, {Name: "edns0"}},
+	})
+	expected := "nameserver 1.1.1.1\nnameserver 8.8.8.8\nsearch ns1.svc.cluster.local my.dns.search.suffix\noptions ndots:2 edns0\n"
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Errorf("unexpected resolv.conf: %s", d)
+	}
+}
+
+func TestTaskSpecToPSteps_PodTemplate(t *testing.T) {
+	user := int64(1000)
+	group := int64(2000)
+	stepUser := int64(0)
+	meta := podMetadata{template: &pod.Template{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"registry.local", "git.local"}}},
+		Env:         []corev1.EnvVar{{Name: "FROM_POD", Value: "pod"}, {Name: "OVERRIDDEN", Value: "pod"}},
+		SecurityContext: &corev1.PodSecurityContext{
+			RunAsUser:  &user,
+			RunAsGroup: &group,
+		},
+		DNSConfig: &corev1.PodDNSConfig{Nameservers: []string{"1.1.1.1"}},
+	}}
+	spec := v1.TaskSpec{Steps: []v1.Step{{
+		Name:    "pod-user",
+		Image:   "alpine",
+		Command: []string{"id"},
+		Env:     []corev1.EnvVar{{Name: "OVERRIDDEN", Value: "step"}},
+	}, {
+		Name:            "step-user",
+		Image:           "alpine",
+		Command:         []string{"id"},
+		SecurityContext: &corev1.SecurityContext{RunAsUser: &stepUser},
+	}}}
+	steps, err := taskSpecToPSteps(context.Background(), &fakeClient{}, spec, location{}, "task", meta, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, steps)
+	if user := execs[0].Meta.User; user != "1000:2000" {
+		t.Errorf("expected the first step to run as the pod user 1000:2000, got %q", user)
+	}
+	if user := execs[1].Meta.User; user != "0:2000" {
+		t.Errorf("expected the second step to run as its own user 0 and the pod group 2000, got %q", user)
+	}
+	hosts := []string{}
+	for _, h := range execs[0].Meta.ExtraHosts {
+		hosts = append(hosts, h.Host+"="+h.IP)
+	}
+	if d := cmp.Diff([]string{"registry.local=10.0.0.1", "git.local=10.0.0.1"}, hosts); d != "" {
+		t.Errorf("unexpected extra hosts: %s", d)
+	}
+	env := map[string]string{}
+	for _, e := range execs[0].Meta.Env {
+		for i := range e {
+			if e[i] == '=' {
+				env[e[:i]] = e[i+1:]
+				break
+			}
+		}
+	}
+	if env["FROM_POD"] != "pod" || env["OVERRIDDEN"] != "step" {
+		t.Errorf("unexpected env: %v", env)
+	}
+	resolvConfMounted := false
+	for _, m := range execs[0].Mounts {
+		if m.Dest == "/etc/resolv.conf" && m.MountType == pb.MountType_BIND && m.Readonly {
+			resolvConfMounted = true
+		}
+	}
+	if !resolvConfMounted {
+		t.Errorf("expected /etc/resolv.conf to be mounted from the dnsConfig")
+	}
+}
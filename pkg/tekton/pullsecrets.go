@@ -0,0 +1,64 @@
+package tekton
+
+import (
+	"context"
+
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+	"github.com/vdemeester/buildkit-tekton/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultServiceAccount is the ServiceAccount Kubernetes runs pods with when
+// none is set.
+const defaultServiceAccount = "default"
+
+// imagePullSecrets returns the image pull secrets references of the given
+// ServiceAccount and PodTemplate, the same way the pod would get them in-cluster.
+// An empty ServiceAccount name is the default-service-account of the Tekton
+// configuration, or the default ServiceAccount.
+func imagePullSecrets(ctx context.Context, serviceAccountName string, podTemplate *pod.Template, serviceAccounts map[string]*corev1.ServiceAccount) []corev1.LocalObjectReference {
+	if serviceAccountName == "" {
+		serviceAccountName = config.FromContext(ctx).Defaults.DefaultServiceAccount
+	}
+	if serviceAccountName == "" {
+		serviceAccountName = defaultServiceAccount
+	}
+	refs := []corev1.LocalObjectReference{}
+	if sa, ok := serviceAccounts[serviceAccountName]; ok && sa != nil {
+		refs = append(refs, sa.ImagePullSecrets...)
+	}
+	if podTemplate != nil {
+		refs = append(refs, podTemplate.ImagePullSecrets...)
+	}
+	return refs
+}
+
+// registerImagePullSecrets loads the given image pull secrets into the
+// credentials store from the context so that they are used to resolve and pull
+// step and sidecar images. Secrets that cannot be used are reported as
+// warnings, at the given location.
+func registerImagePullSecrets(ctx context.Context, c client.Client, loc location, refs []corev1.LocalObjectReference, secrets map[string]*corev1.Secret) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	store := credentials.FromContext(ctx)
+	if store == nil {
+		warn(ctx, c, loc, "imagePullSecrets are only supported through tkn-local, ignoring them")
+		return nil
+	}
+	for _, ref := range refs {
+		secret, ok := secrets[ref.Name]
+		if !ok || secret == nil {
+			// Kubernetes ignores missing image pull secrets, so do we
+			warn(ctx, c, loc, "imagePullSecret %s not found in context, ignoring it", ref.Name)
+			continue
+		}
+		if err := store.AddSecret(secret); err != nil {
+			return loc.wrapError(ctx, errors.Wrapf(err, "imagePullSecret %s", ref.Name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
+	"github.com/tektoncd/pipeline/test/diff"
+	"github.com/vdemeester/buildkit-tekton/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegisterImagePullSecrets(t *testing.T) {
+	serviceAccounts := map[string]*corev1.ServiceAccount{
+		"builder": {
+			ObjectMeta:       metav1.ObjectMeta{Name: "builder"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "sa-regcred"}},
+		},
+		"default": {
+			ObjectMeta:       metav1.ObjectMeta{Name: "default"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "default-regcred"}},
+		},
+	}
+	secrets := map[string]*corev1.Secret{
+		"sa-regcred": {
+			ObjectMeta: metav1.ObjectMeta{Name: "sa-regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"username":"foo","password":"bar"}}}`),
+			},
+		},
+		"pod-regcred": {
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"ghcr.io":{"username":"bar","password":"baz"}}}`),
+			},
+		},
+	}
+	podTemplate := &pod.Template{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pod-regcred"}, {Name: "missing"}},
+	}
+	store := credentials.NewStore()
+	ctx := credentials.ToContext(context.Background(), store)
+
+	refs := imagePullSecrets(ctx, "builder", podTemplate, serviceAccounts)
+	c := &fakeClient{}
+	if err := registerImagePullSecrets(ctx, c, location{}, refs, secrets); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"quay.io", "ghcr.io"} {
+		if _, ok := store.Get(host); !ok {
+			t.Errorf("expected credentials for %s", host)
+		}
+	}
+	if d := cmp.Diff([]string{"imagePullSecret missing not found in context, ignoring it"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+
+	// Without ServiceAccount, the default one is used
+	refs = imagePullSecrets(context.Background(), "", nil, serviceAccounts)
+	if d := cmp.Diff([]corev1.LocalObjectReference{{Name: "default-regcred"}}, refs); d != "" {
+		t.Errorf("refs mismatch %s", diff.PrintWantGot(d))
+	}
+
+	// Without credentials store (not through tkn-local), they are ignored
+	c = &fakeClient{}
+	if err := registerImagePullSecrets(context.Background(), c, location{}, refs, secrets); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"imagePullSecrets are only supported through tkn-local, ignoring them"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+}
@@ -0,0 +1,46 @@
+package tekton
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+)
+
+// computeResourcesRunOptions returns the run options enforcing (best-effort)
+// the resources of a step of a Task (named name). BuildKit cannot set cpu or
+// memory limits on a step: the steps of a Task run in a per-Task child of the
+// configured cgroup parent, whose limits are set by the operator. Ulimits
+// (e.g. nofile, nproc) are the configured ones. As the limits of a step are
+// never enforced as such, they are reported at loc, the location of the step.
+func computeResourcesRunOptions(ctx context.Context, c client.Client, loc location, name string, step v1.Step) []llb.RunOption {
+	cfg := config.FromContext(ctx)
+	opts := []llb.RunOption{}
+	limitsLoc := loc.child("computeResources", "limits")
+	if cfg.CgroupParent != "" {
+		opts = append(opts, llb.WithCgroupParent(taskCgroupParent(cfg.CgroupParent, name)))
+		if len(step.ComputeResources.Limits) > 0 {
+			warn(ctx, c, limitsLoc, "step %s: computeResources limits are not enforced per step, only the limits of the cgroup-parent %s apply", step.Name, cfg.CgroupParent)
+		}
+	} else if len(step.ComputeResources.Limits) > 0 {
+		warn(ctx, c, limitsLoc, "step %s: computeResources limits are not enforced without a cgroup-parent", step.Name)
+	}
+	for _, u := range cfg.Ulimits {
+		opts = append(opts, llb.AddUlimit(u.Name, u.Soft, u.Hard))
+	}
+	return opts
+}
+
+// taskCgroupParent returns the cgroup parent of the steps of a Task (named
+// name). A systemd slice parent (e.g. tekton.slice:) cannot be nested, so it
+// is used as is.
+func taskCgroupParent(parent, name string) string {
+	if strings.HasSuffix(parent, ":") {
+		return parent
+	}
+	return path.Join(parent, name)
+}
@@ -0,0 +1,79 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestTaskCgroupParent(t *testing.T) {
+	tests := []struct {
+		parent   string
+		name     string
+		expected string
+	}{
+		{parent: "/tekton", name: "build", expected: "/tekton/build"},
+		{parent: "tekton", name: "finally/cleanup", expected: "tekton/finally/cleanup"},
+		{parent: "tekton.slice:", name: "build", expected: "tekton.slice:"},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.parent+"/"+tc.name, func(t *testing.T) {
+			if got := taskCgroupParent(tc.parent, tc.name); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestTaskSpecToPSteps_ComputeResources(t *testing.T) {
+	cfg := &config.Config{
+		EntrypointImage: config.DefaultEntrypointImage(),
+		CgroupParent:    "/tekton",
+		Ulimits:         []config.Ulimit{{Name: llb.UlimitNofile, Soft: 1024, Hard: 4096}},
+	}
+	ctx := cfg.ToContext(context.Background())
+	spec := v1.TaskSpec{Steps: []v1.Step{{
+		Name:    "test",
+		Image:   "golang",
+		Command: []string{"go", "test", "./..."},
+		ComputeResources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}}}
+	c := &fakeClient{}
+	steps, err := taskSpecToPSteps(ctx, c, spec, location{}, "unit", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"step test: computeResources limits are not enforced per step, only the limits of the cgroup-parent /tekton apply"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+	execs := stepExecs(t, steps)
+	if parent := execs[0].Meta.CgroupParent; parent != "/tekton/unit" {
+		t.Errorf("expected the step to run under the /tekton/unit cgroup, got %q", parent)
+	}
+	if d := cmp.Diff([]*pb.Ulimit{{Name: "nofile", Soft: 1024, Hard: 4096}}, execs[0].Meta.Ulimit, cmp.Comparer(func(a, b *pb.Ulimit) bool {
+		return a.Name == b.Name && a.Soft == b.Soft && a.Hard == b.Hard
+	})); d != "" {
+		t.Errorf("unexpected ulimits: %s", d)
+	}
+
+	// Without a cgroup parent, limits are not enforced
+	c = &fakeClient{}
+	ctx = (&config.Config{EntrypointImage: config.DefaultEntrypointImage()}).ToContext(context.Background())
+	if _, err := taskSpecToPSteps(ctx, c, spec, location{}, "unit", podMetadata{}, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"step test: computeResources limits are not enforced without a cgroup-parent"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+}
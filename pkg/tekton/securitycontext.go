@@ -0,0 +1,101 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/pb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// containerUser returns the user ("uid[:gid]") a container runs as, the
+// container securityContext taking precedence over the pod one, field by
+// field (like Kubernetes). It returns an empty string to use the image user.
+// Ignored fields are reported at loc, the location of the container.
+func containerUser(ctx context.Context, c client.Client, loc location, name string, podSC *corev1.PodSecurityContext, sc *corev1.SecurityContext) string {
+	var uid, gid *int64
+	if podSC != nil {
+		uid, gid = podSC.RunAsUser, podSC.RunAsGroup
+	}
+	if sc != nil {
+		if sc.RunAsUser != nil {
+			uid = sc.RunAsUser
+		}
+		if sc.RunAsGroup != nil {
+			gid = sc.RunAsGroup
+		}
+	}
+	if uid == nil {
+		if gid != nil {
+			// The image user would need to be resolved to a uid first
+			warn(ctx, c, loc.child("securityContext", "runAsGroup"), "%s: securityContext.runAsGroup is ignored without runAsUser", name)
+		}
+		return ""
+	}
+	if gid == nil {
+		return fmt.Sprintf("%d", *uid)
+	}
+	return fmt.Sprintf("%d:%d", *uid, *gid)
+}
+
+// securityMode returns the BuildKit security mode of a container. Privileged
+// containers run in insecure mode, which requires the security.insecure
+// entitlement (e.g. tkn-local run --allow security.insecure).
+func securityMode(sc *corev1.SecurityContext) pb.SecurityMode {
+	if sc != nil && sc.Privileged != nil && *sc.Privileged {
+		return pb.SecurityMode_INSECURE
+	}
+	return pb.SecurityMode_SANDBOX
+}
+
+// readOnlyRootFilesystem returns whether the root filesystem of a container
+// is mounted read-only.
+func readOnlyRootFilesystem(sc *corev1.SecurityContext) bool {
+	return sc != nil && sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+}
+
+// securityContextRunOptions returns the run options of a step from its
+// securityContext: privileged and readOnlyRootFilesystem. The user is handled
+// by containerUser.
+func securityContextRunOptions(ctx context.Context, c client.Client, loc location, name string, sc *corev1.SecurityContext) []llb.RunOption {
+	if sc == nil {
+		return nil
+	}
+	warnSecurityContext(ctx, c, loc, name, sc)
+	opts := []llb.RunOption{}
+	if securityMode(sc) == pb.SecurityMode_INSECURE {
+		opts = append(opts, llb.Security(llb.SecurityModeInsecure))
+	}
+	if readOnlyRootFilesystem(sc) {
+		opts = append(opts, llb.ReadonlyRootFS())
+	}
+	return opts
+}
+
+// warnSecurityContext warns about the securityContext fields of a container
+// that cannot be honoured by BuildKit. Capabilities cannot be changed one by
+// one: a privileged container gets all of them, others get the default set.
+// Warnings are reported at loc, the location of the container.
+func warnSecurityContext(ctx context.Context, c client.Client, loc location, name string, sc *corev1.SecurityContext) {
+	loc = loc.child("securityContext")
+	if sc.Capabilities != nil {
+		if len(sc.Capabilities.Add) > 0 && securityMode(sc) != pb.SecurityMode_INSECURE {
+			warn(ctx, c, loc.child("capabilities", "add"), "%s: securityContext.capabilities.add %v is not supported, ignoring (use privileged instead)", name, sc.Capabilities.Add)
+		}
+		if len(sc.Capabilities.Drop) > 0 {
+			warn(ctx, c, loc.child("capabilities", "drop"), "%s: securityContext.capabilities.drop %v is not supported, ignoring", name, sc.Capabilities.Drop)
+		}
+	}
+	rest := sc.DeepCopy()
+	rest.RunAsUser = nil
+	rest.RunAsGroup = nil
+	rest.Privileged = nil
+	rest.ReadOnlyRootFilesystem = nil
+	rest.Capabilities = nil
+	if !equality.Semantic.DeepEqual(rest, &corev1.SecurityContext{}) {
+		warn(ctx, c, loc, "%s: only securityContext.runAsUser, runAsGroup, privileged, readOnlyRootFilesystem and capabilities are supported, ignoring the other fields", name)
+	}
+}
@@ -0,0 +1,145 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestContainerUser(t *testing.T) {
+	podUser := int64(1000)
+	podGroup := int64(2000)
+	stepUser := int64(0)
+	stepGroup := int64(3000)
+	tests := []struct {
+		name     string
+		podSC    *corev1.PodSecurityContext
+		sc       *corev1.SecurityContext
+		expected string
+		warnings []string
+	}{{
+		name:     "none",
+		expected: "",
+	}, {
+		name:     "pod user and group",
+		podSC:    &corev1.PodSecurityContext{RunAsUser: &podUser, RunAsGroup: &podGroup},
+		expected: "1000:2000",
+	}, {
+		name:     "step user only",
+		sc:       &corev1.SecurityContext{RunAsUser: &stepUser},
+		expected: "0",
+	}, {
+		name:     "step user and group",
+		sc:       &corev1.SecurityContext{RunAsUser: &stepUser, RunAsGroup: &stepGroup},
+		expected: "0:3000",
+	}, {
+		name:     "step group overrides pod group",
+		podSC:    &corev1.PodSecurityContext{RunAsUser: &podUser, RunAsGroup: &podGroup},
+		sc:       &corev1.SecurityContext{RunAsGroup: &stepGroup},
+		expected: "1000:3000",
+	}, {
+		name:     "group without user",
+		sc:       &corev1.SecurityContext{RunAsGroup: &stepGroup},
+		expected: "",
+		warnings: []string{"step: securityContext.runAsGroup is ignored without runAsUser"},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeClient{}
+			if got := containerUser(context.Background(), c, location{}, "step", tc.podSC, tc.sc); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+			if d := cmp.Diff(tc.warnings, c.warnings); d != "" {
+				t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+			}
+		})
+	}
+}
+
+func TestTaskSpecToPSteps_SecurityContext(t *testing.T) {
+	privileged := true
+	readOnly := true
+	spec := v1.TaskSpec{Steps: []v1.Step{{
+		Name:    "default",
+		Image:   "alpine",
+		Command: []string{"true"},
+	}, {
+		Name:            "privileged",
+		Image:           "quay.io/buildah/stable",
+		Command:         []string{"buildah", "version"},
+		SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+	}, {
+		Name:    "read-only",
+		Image:   "alpine",
+		Command: []string{"true"},
+		SecurityContext: &corev1.SecurityContext{
+			ReadOnlyRootFilesystem: &readOnly,
+			Capabilities:           &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	}}}
+	c := &fakeClient{}
+	steps, err := taskSpecToPSteps(context.Background(), c, spec, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"step read-only: securityContext.capabilities.drop [ALL] is not supported, ignoring"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+	execs := stepExecs(t, steps)
+	rootReadOnly := func(e *pb.ExecOp) bool {
+		for _, m := range e.Mounts {
+			if m.Dest == "/" {
+				return m.Readonly
+			}
+		}
+		return false
+	}
+	if execs[0].Security != pb.SecurityMode_SANDBOX || rootReadOnly(execs[0]) {
+		t.Errorf("expected the default step to run sandboxed with a writable root")
+	}
+	if execs[1].Security != pb.SecurityMode_INSECURE {
+		t.Errorf("expected the privileged step to run in insecure mode, got %s", execs[1].Security)
+	}
+	if len(execs) != 2 {
+		t.Fatalf("expected the read-only step to have no root output, got %d execs", len(execs))
+	}
+
+	// The read-only step is still chained through its output mount
+	stepStates, err := pstepToState(&fakeClient{}, steps, llb.Scratch(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := stepStates[len(stepStates)-1].Marshal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chained := []*pb.ExecOp{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatal(err)
+		}
+		if exec := op.GetExec(); exec != nil {
+			chained = append(chained, exec)
+		}
+	}
+	if len(chained) != 3 {
+		t.Fatalf("expected the 3 steps to be chained, got %d", len(chained))
+	}
+	readOnlySteps := 0
+	for _, e := range chained {
+		if rootReadOnly(e) {
+			readOnlySteps++
+		}
+	}
+	if readOnlySteps != 1 {
+		t.Errorf("expected one step with a read-only root, got %d", readOnlySteps)
+	}
+}
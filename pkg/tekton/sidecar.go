@@ -0,0 +1,376 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultSidecarStartDelay is how long we wait for a sidecar without
+	// readiness probe before considering it ready.
+	defaultSidecarStartDelay = 2 * time.Second
+	// sidecarReadinessTimeout is how long we wait for a sidecar readiness
+	// probe to succeed.
+	sidecarReadinessTimeout = 5 * time.Minute
+	// defaultProbeTimeout is how long a readiness probe may run without
+	// timeoutSeconds (the Kubernetes default).
+	defaultProbeTimeout = time.Second
+)
+
+// Sidecar is a container started alongside the steps of a Task, using the
+// BuildKit gateway container API. Sidecars share the host network (of the
+// BuildKit worker) with the steps, so steps can reach them on localhost.
+type Sidecar struct {
+	name       string
+	image      string
+	args       []string
+	env        []string
+	cwd        string
+	user       string
+	security   pb.SecurityMode
+	readOnly   bool
+	script     *llb.State
+	probe      *corev1.Probe
+	useImageEP bool
+}
+
+// TaskSidecars are the sidecars of a Task, with the state of its steps (the
+// last one): the sidecars run while this state is solved. after are the
+// (names of the) Tasks with sidecars this Task runs after.
+type TaskSidecars struct {
+	name     string
+	state    llb.State
+	sidecars []Sidecar
+	after    []string
+}
+
+// LogWriterFunc returns the writer a sidecar (named name) logs are written to.
+type LogWriterFunc func(name string) io.WriteCloser
+
+type logWriterKey struct{}
+
+// WithLogWriter enriches a context with a LogWriterFunc, used to stream
+// sidecar logs (e.g. into the progress output).
+func WithLogWriter(ctx context.Context, fn LogWriterFunc) context.Context {
+	return context.WithValue(ctx, logWriterKey{}, fn)
+}
+
+func logWriter(ctx context.Context, name string) io.WriteCloser {
+	if fn, ok := ctx.Value(logWriterKey{}).(LogWriterFunc); ok && fn != nil {
+		return fn(name)
+	}
+	return logrus.WithField("sidecar", name).WriterLevel(logrus.InfoLevel)
+}
+
+// taskSpecToSidecars returns the sidecars of a Task (named name). Ignored
+// fields are reported at their location under loc, the location of the Task.
+func taskSpecToSidecars(ctx context.Context, c client.Client, t v1.TaskSpec, loc location, name string, meta podMetadata, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) ([]Sidecar, error) {
+	sidecars := make([]Sidecar, len(t.Sidecars))
+	for i, s := range t.Sidecars {
+		ref, err := reference.ParseNormalizedNamed(s.Image)
+		if err != nil {
+			return nil, err
+		}
+		sidecar := Sidecar{
+			name:  name + "/" + s.Name,
+			image: ref.String(),
+			cwd:   s.WorkingDir,
+			probe: s.ReadinessProbe,
+		}
+		// Like the steps, env is resolved with its valueFrom and envFrom
+		env, err := stepEnv(s.EnvFrom, podTemplateEnv(meta.template, s.Env), meta, configs, secrets)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sidecar %s", s.Name)
+		}
+		for _, e := range env {
+			sidecar.env = append(sidecar.env, e.name+"="+e.value)
+		}
+		sidecarLoc := loc.child("sidecars", i)
+		if s.ReadinessProbe != nil && s.ReadinessProbe.Exec == nil {
+			warn(ctx, c, sidecarLoc.child("readinessProbe"), "sidecar %s: only exec readiness probes are supported, using initialDelaySeconds only", s.Name)
+		}
+		if s.SecurityContext != nil {
+			warnSecurityContext(ctx, c, sidecarLoc, "sidecar "+s.Name, s.SecurityContext)
+			sidecar.user = containerUser(ctx, c, sidecarLoc, "sidecar "+s.Name, nil, s.SecurityContext)
+			sidecar.security = securityMode(s.SecurityContext)
+			sidecar.readOnly = readOnlyRootFilesystem(s.SecurityContext)
+		}
+		switch {
+		case s.Script != "":
+			filename, scriptSt := files.Script(sidecar.name, fmt.Sprintf("sidecar-script-%d", i), s.Script)
+			sidecar.script = &scriptSt
+			sidecar.args = []string{filepath.Join(scriptsDir, filename)}
+		case len(s.Command) > 0:
+			sidecar.args = append(append([]string{}, s.Command...), s.Args...)
+		default:
+			sidecar.args = s.Args
+			sidecar.useImageEP = true
+		}
+		sidecars[i] = sidecar
+	}
+	return sidecars, nil
+}
+
+// SolveWithSidecars solves the given Tasks concurrently, each while its
+// sidecars run: they are started (and ready) before its steps run, and stopped
+// once they are done. A Task waits for the Tasks with sidecars it runs after,
+// so that they are solved with their own sidecars; the other Tasks it depends
+// on are solved along. As the solves of a build share their results, the steps
+// are not run again when the whole state is solved.
+func SolveWithSidecars(ctx context.Context, c client.Client, tasks []TaskSidecars) error {
+	done := make(map[string]chan struct{}, len(tasks))
+	for _, t := range tasks {
+		done[t.name] = make(chan struct{})
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, t := range tasks {
+		t := t
+		eg.Go(func() error {
+			for _, a := range t.after {
+				// A failed Task is never done, its error cancels ctx
+				select {
+				case <-done[a]:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if err := solveWithSidecars(ctx, c, t); err != nil {
+				return errors.Wrapf(err, "task %s", t.name)
+			}
+			close(done[t.name])
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func solveWithSidecars(ctx context.Context, c client.Client, t TaskSidecars) error {
+	stop, err := startSidecars(ctx, c, t.sidecars)
+	if err != nil {
+		return err
+	}
+	defer stop(ctx)
+	def, err := t.state.Marshal(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.Solve(ctx, client.SolveRequest{
+		Definition: def.ToPB(),
+		Evaluate:   true,
+	})
+	return err
+}
+
+// startSidecars starts the given sidecars and waits for them to be ready.
+// The returned function stops them, and must be called once the steps are done.
+func startSidecars(ctx context.Context, c client.Client, sidecars []Sidecar) (func(context.Context), error) {
+	containers := []client.Container{}
+	release := func(ctx context.Context) {
+		for _, ctr := range containers {
+			if err := ctr.Release(ctx); err != nil {
+				logrus.Warnf("failed to release sidecar: %v", err)
+			}
+		}
+	}
+	for _, s := range sidecars {
+		ctr, err := startSidecar(ctx, c, s)
+		if ctr != nil {
+			containers = append(containers, ctr)
+		}
+		if err != nil {
+			release(ctx)
+			return nil, errors.Wrapf(err, "failed to start sidecar %s", s.name)
+		}
+	}
+	return release, nil
+}
+
+func startSidecar(ctx context.Context, c client.Client, s Sidecar) (client.Container, error) {
+	args := s.args
+	env := s.env
+	cwd := s.cwd
+	user := s.user
+	if s.useImageEP {
+		img, err := resolveImageConfig(ctx, c, s.image)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			args = img.Cmd
+		}
+		args = append(append([]string{}, img.Entrypoint...), args...)
+		env = append(append([]string{}, img.Env...), env...)
+		if cwd == "" {
+			cwd = img.WorkingDir
+		}
+		if user == "" {
+			user = img.User
+		}
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no command to run")
+	}
+	if cwd == "" {
+		cwd = "/"
+	}
+
+	mounts := []client.Mount{}
+	rootRef, err := solveRef(ctx, c, llb.Image(s.image, llb.WithMetaResolver(c)))
+	if err != nil {
+		return nil, err
+	}
+	mounts = append(mounts, client.Mount{Dest: "/", Ref: rootRef, MountType: pb.MountType_BIND, Readonly: s.readOnly})
+	if s.script != nil {
+		scriptRef, err := solveRef(ctx, c, *s.script)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, client.Mount{Dest: scriptsDir, Ref: scriptRef, MountType: pb.MountType_BIND, Readonly: true})
+	}
+
+	ctr, err := c.NewContainer(ctx, client.NewContainerRequest{
+		Mounts:  mounts,
+		NetMode: pb.NetMode_HOST,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logs := logWriter(ctx, "[tekton] sidecar "+s.name)
+	proc, err := ctr.Start(ctx, client.StartRequest{
+		Args:         args,
+		Env:          env,
+		Cwd:          cwd,
+		User:         user,
+		SecurityMode: s.security,
+		Stdout:       logs,
+		Stderr:       logs,
+	})
+	if err != nil {
+		logs.Close()
+		return ctr, err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- proc.Wait()
+		logs.Close()
+	}()
+
+	return ctr, waitForSidecar(ctx, ctr, s, env, user, exited)
+}
+
+// waitForSidecar waits for the sidecar to be ready, either using its readiness
+// probe (exec only, run with the env and user of the sidecar) or a start delay.
+func waitForSidecar(ctx context.Context, ctr client.Container, s Sidecar, env []string, user string, exited <-chan error) error {
+	delay := defaultSidecarStartDelay
+	if s.probe != nil {
+		delay = time.Duration(s.probe.InitialDelaySeconds) * time.Second
+	}
+	if err := sleep(ctx, delay, exited); err != nil {
+		return err
+	}
+	if s.probe == nil {
+		return nil
+	}
+	if s.probe.Exec == nil {
+		// Reported by taskSpecToSidecars
+		return nil
+	}
+
+	period := time.Duration(s.probe.PeriodSeconds) * time.Second
+	if period <= 0 {
+		period = time.Second
+	}
+	timeout := time.Duration(s.probe.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	req := client.StartRequest{
+		Args: s.probe.Exec.Command,
+		Env:  env,
+		User: user,
+		Cwd:  "/",
+	}
+	deadline := time.Now().Add(sidecarReadinessTimeout)
+	for time.Now().Before(deadline) {
+		ready, err := runProbe(ctx, ctr, req, timeout)
+		if err != nil {
+			return errors.Wrap(err, "failed to run readiness probe")
+		}
+		if ready {
+			return nil
+		}
+		if err := sleep(ctx, period, exited); err != nil {
+			return err
+		}
+	}
+	return errors.Errorf("readiness probe did not succeed within %s", sidecarReadinessTimeout)
+}
+
+// runProbe runs a readiness probe once, returning whether it succeeded within
+// timeout. A probe that times out is failed, like with Kubernetes.
+func runProbe(ctx context.Context, ctr client.Container, req client.StartRequest, timeout time.Duration) (bool, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	proc, err := ctr.Start(probeCtx, req)
+	if err != nil {
+		if probeCtx.Err() == context.DeadlineExceeded {
+			return false, nil
+		}
+		return false, err
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- proc.Wait()
+	}()
+	select {
+	case err := <-waited:
+		return err == nil, nil
+	case <-probeCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+}
+
+// sleep waits for the given duration, unless the context is cancelled or the
+// sidecar exits in the meantime.
+func sleep(ctx context.Context, d time.Duration, exited <-chan error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-exited:
+		return errors.Errorf("sidecar exited before being ready: %v", err)
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func solveRef(ctx context.Context, c client.Client, st llb.State) (client.Reference, error) {
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Solve(ctx, client.SolveRequest{
+		Definition: def.ToPB(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.SingleRef()
+}
@@ -0,0 +1,335 @@
+package tekton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestValidateTaskSpec_WithSidecars(t *testing.T) {
+	ctx := context.Background()
+	spec := v1.TaskSpec{
+		Steps: []v1.Step{{
+			Name:   "test",
+			Image:  "postgres:16",
+			Script: "pg_isready -h localhost",
+		}},
+		Sidecars: []v1.Sidecar{{
+			Name:  "postgres",
+			Image: "postgres:16",
+			Env: []corev1.EnvVar{{
+				Name:  "POSTGRES_PASSWORD",
+				Value: "secret",
+			}},
+		}},
+	}
+
+	if err := validateTaskSpec(ctx, &fakeClient{}, spec, location{}); err != nil {
+		t.Errorf("validateTaskSpec() with Sidecars should not error, got: %v", err)
+	}
+
+	spec.Sidecars[0].VolumeMounts = []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}
+	if err := validateTaskSpec(ctx, &fakeClient{}, spec, location{}); err == nil {
+		t.Errorf("validateTaskSpec() with Sidecar VolumeMounts should error")
+	}
+}
+
+func TestTaskSpecToSidecars(t *testing.T) {
+	spec := v1.TaskSpec{
+		Sidecars: []v1.Sidecar{{
+			Name:    "registry",
+			Image:   "registry:2",
+			Command: []string{"registry"},
+			Args:    []string{"serve", "/etc/docker/registry/config.yml"},
+			Env: []corev1.EnvVar{{
+				Name:  "REGISTRY_HTTP_ADDR",
+				Value: "0.0.0.0:5000",
+			}, {
+				Name: "REGISTRY_HTTP_SECRET",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "registry"},
+					Key:                  "secret",
+				}},
+			}, {
+				Name:      "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			}},
+		}, {
+			Name:  "postgres",
+			Image: "postgres:16",
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(5432)}},
+			},
+		}, {
+			Name:   "script",
+			Image:  "alpine",
+			Script: "sleep infinity",
+		}},
+	}
+	secrets := map[string]*corev1.Secret{
+		"registry": {
+			ObjectMeta: metav1.ObjectMeta{Name: "registry"},
+			Data:       map[string][]byte{"secret": []byte("s3cr3t")},
+		},
+	}
+	c := &fakeClient{}
+	sidecars, err := taskSpecToSidecars(context.Background(), c, spec, location{}, "task", podMetadata{name: "task-pod"}, nil, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"sidecar postgres: only exec readiness probes are supported, using initialDelaySeconds only"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+	if len(sidecars) != 3 {
+		t.Fatalf("expected 3 sidecars, got %d", len(sidecars))
+	}
+
+	registry := sidecars[0]
+	if registry.name != "task/registry" || registry.image != "docker.io/library/registry:2" {
+		t.Errorf("unexpected sidecar: %+v", registry)
+	}
+	if d := cmp.Diff([]string{"registry", "serve", "/etc/docker/registry/config.yml"}, registry.args); d != "" {
+		t.Errorf("unexpected args: %s", d)
+	}
+	if d := cmp.Diff([]string{"REGISTRY_HTTP_ADDR=0.0.0.0:5000", "REGISTRY_HTTP_SECRET=s3cr3t", "POD_NAME=task-pod"}, registry.env); d != "" {
+		t.Errorf("unexpected env: %s", d)
+	}
+	if registry.useImageEP {
+		t.Errorf("sidecar with command should not use the image entrypoint")
+	}
+	if !sidecars[1].useImageEP {
+		t.Errorf("sidecar without command should use the image entrypoint")
+	}
+	if sidecars[2].script == nil || len(sidecars[2].args) != 1 {
+		t.Errorf("sidecar with script should run the script, got %+v", sidecars[2])
+	}
+}
+
+func TestTaskSpecToSidecars_MissingEnvSource(t *testing.T) {
+	spec := v1.TaskSpec{
+		Sidecars: []v1.Sidecar{{
+			Name:  "db",
+			Image: "postgres:16",
+			Env: []corev1.EnvVar{{
+				Name: "POSTGRES_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+					Key:                  "password",
+				}},
+			}},
+		}},
+	}
+	if _, err := taskSpecToSidecars(context.Background(), &fakeClient{}, spec, location{}, "task", podMetadata{}, nil, nil); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	}
+}
+
+// sidecarClient is a gateway client recording the sidecars (containers) it
+// runs and the states it solves.
+type sidecarClient struct {
+	fakeClient
+	mu     sync.Mutex
+	events []string
+	// parallel, if set, makes each solve of steps wait for the others to be
+	// running
+	parallel *sync.WaitGroup
+}
+
+func (c *sidecarClient) record(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, fmt.Sprintf(format, args...))
+}
+
+func (c *sidecarClient) Solve(_ context.Context, req client.SolveRequest) (*client.Result, error) {
+	if req.Evaluate {
+		c.record("solve")
+	}
+	if req.Evaluate && c.parallel != nil {
+		c.parallel.Done()
+		waited := make(chan struct{})
+		go func() {
+			c.parallel.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("solves are not concurrent")
+		}
+	}
+	res := client.NewResult()
+	res.SetRef(fakeRef{})
+	return res, nil
+}
+
+func (c *sidecarClient) NewContainer(_ context.Context, _ client.NewContainerRequest) (client.Container, error) {
+	return &fakeContainer{client: c, done: make(chan struct{})}, nil
+}
+
+type fakeRef struct {
+	client.Reference
+}
+
+type fakeContainer struct {
+	client.Container
+	client *sidecarClient
+	done   chan struct{}
+}
+
+func (c *fakeContainer) Start(_ context.Context, req client.StartRequest) (client.ContainerProcess, error) {
+	if req.Stdout == nil {
+		// Readiness probe
+		c.client.record("probe %v", req.Args)
+		return fakeProcess{}, nil
+	}
+	c.client.record("start %v", req.Args)
+	return fakeProcess{done: c.done}, nil
+}
+
+func (c *fakeContainer) Release(_ context.Context) error {
+	c.client.record("release")
+	close(c.done)
+	return nil
+}
+
+// fakeProcess exits once done is closed (immediately if nil).
+type fakeProcess struct {
+	client.ContainerProcess
+	done chan struct{}
+}
+
+func (p fakeProcess) Wait() error {
+	if p.done != nil {
+		<-p.done
+	}
+	return nil
+}
+
+func TestSolveWithSidecars(t *testing.T) {
+	ctx := context.Background()
+	probe := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"pg_isready"}}}}
+	tasks := []TaskSidecars{{
+		name:     "migrate",
+		state:    llb.Image("postgres:16").Run(llb.Shlex("psql -h localhost")).Root(),
+		sidecars: []Sidecar{{name: "migrate/db", image: "docker.io/library/postgres:16", args: []string{"postgres"}, probe: probe}},
+	}, {
+		name:     "test",
+		state:    llb.Image("redis:7").Run(llb.Shlex("redis-cli ping")).Root(),
+		sidecars: []Sidecar{{name: "test/cache", image: "docker.io/library/redis:7", args: []string{"redis-server"}, probe: probe}},
+		after:    []string{"migrate"},
+	}}
+	c := &sidecarClient{}
+	if err := SolveWithSidecars(ctx, c, tasks); err != nil {
+		t.Fatal(err)
+	}
+	// Each Task steps are solved while (and only while) its sidecars run,
+	// after the Tasks it runs after
+	expected := []string{
+		"start [postgres]", "probe [pg_isready]", "solve", "release",
+		"start [redis-server]", "probe [pg_isready]", "solve", "release",
+	}
+	if d := cmp.Diff(expected, c.events); d != "" {
+		t.Errorf("events mismatch %s", diff.PrintWantGot(d))
+	}
+
+	// Independent Tasks are solved concurrently
+	tasks[1].after = nil
+	c = &sidecarClient{parallel: &sync.WaitGroup{}}
+	c.parallel.Add(len(tasks))
+	if err := SolveWithSidecars(ctx, c, tasks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForSidecar_Probe(t *testing.T) {
+	ctx := context.Background()
+	s := Sidecar{name: "task/db", probe: &corev1.Probe{
+		ProbeHandler:   corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"pg_isready"}}},
+		TimeoutSeconds: 1,
+		PeriodSeconds:  1,
+	}}
+	ctr := &probeContainer{}
+	start := time.Now()
+	if err := waitForSidecar(ctx, ctr, s, []string{"PGUSER=app"}, "postgres", nil); err != nil {
+		t.Fatal(err)
+	}
+	// The first probe hangs and times out, the second one succeeds
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("hanging probe was not timed out, took %s", d)
+	}
+	if len(ctr.requests) != 2 {
+		t.Fatalf("expected 2 probes, got %d", len(ctr.requests))
+	}
+	for _, req := range ctr.requests {
+		// Probes run in the sidecar environment
+		if d := cmp.Diff([]string{"PGUSER=app"}, req.Env); d != "" || req.User != "postgres" {
+			t.Errorf("unexpected probe env %v and user %q", req.Env, req.User)
+		}
+	}
+}
+
+// probeContainer is a sidecar container whose first readiness probe hangs.
+type probeContainer struct {
+	client.Container
+	requests []client.StartRequest
+}
+
+func (c *probeContainer) Start(_ context.Context, req client.StartRequest) (client.ContainerProcess, error) {
+	c.requests = append(c.requests, req)
+	if len(c.requests) == 1 {
+		return fakeProcess{done: make(chan struct{})}, nil
+	}
+	return fakeProcess{}, nil
+}
+
+func TestTektonToLLB_TaskSidecars(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		example  string
+		expected [][]string
+	}{{
+		example:  "0-taskrun-sidecar",
+		expected: [][]string{{"sidecar-demo-generated", "sidecar-demo-generated/web"}},
+	}, {
+		example:  "1-pipelinerun-sidecar",
+		expected: [][]string{{"sidecar-pipeline-generated/first", "sidecar-pipeline-generated/first/web"}, {"sidecar-pipeline-generated/second", "sidecar-pipeline-generated/second/web", "after sidecar-pipeline-generated/first"}},
+	}} {
+		dt, err := os.ReadFile("../../examples/" + tc.example + "/run.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, tasks, err := TektonToLLB(&fakeClient{})(ctx, ContextResource{Path: "run.yaml", Data: string(dt)}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each Task comes with its own sidecars, in order
+		got := [][]string{}
+		for _, task := range tasks {
+			names := []string{task.name}
+			for _, s := range task.sidecars {
+				names = append(names, s.name)
+			}
+			for _, a := range task.after {
+				names = append(names, "after "+a)
+			}
+			got = append(got, names)
+		}
+		if d := cmp.Diff(tc.expected, got); d != "" {
+			t.Errorf("%s: tasks mismatch %s", tc.example, diff.PrintWantGot(d))
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/errdefs"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// location is a node of a resource file (e.g. a step of a Task), used to
+// report warnings and errors on it, and to map LLB operations to it. The zero
+// location is unknown.
+type location struct {
+	resource *ContextResource
+	// key is the key of the node when it is the value of a field
+	key  *yaml.Node
+	node *yaml.Node
+}
+
+// locations are the locations of the objects of a run (the run itself and
+// the resources it references).
+type locations map[runtime.Object]location
+
+// locate returns the location of the document defining the object of the
+// given kind and metadata in a resource file.
+func locate(resource *ContextResource, kind string, meta metav1.ObjectMeta) location {
+	for _, doc := range yamlDocuments(resource.Data) {
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		if scalar(mappingValue(root, "kind")) != kind {
+			continue
+		}
+		metadata := mappingValue(root, "metadata")
+		if meta.Name != "" && scalar(mappingValue(metadata, "name")) == meta.Name ||
+			meta.Name == "" && scalar(mappingValue(metadata, "generateName")) == meta.GenerateName {
+			return location{resource: resource, node: root}
+		}
+	}
+	return location{}
+}
+
+// child returns the location of a field (by name) or item (by index) under
+// the location. Missing nodes (e.g. fields renamed by a conversion) are
+// located at their closest parent.
+func (l location) child(path ...interface{}) location {
+	for _, p := range path {
+		if l.node == nil {
+			return l
+		}
+		switch p := p.(type) {
+		case string:
+			key, value := mappingField(l.node, p)
+			if value == nil {
+				return l
+			}
+			l.key, l.node = key, value
+		case int:
+			if l.node.Kind != yaml.SequenceNode || p < 0 || p >= len(l.node.Content) {
+				return l
+			}
+			l.key, l.node = nil, l.node.Content[p]
+		}
+	}
+	return l
+}
+
+// lines returns the first and last lines of the location, 0 if unknown.
+func (l location) lines() (int, int) {
+	if l.node == nil {
+		return 0, 0
+	}
+	start := l.node.Line
+	if l.key != nil {
+		start = l.key.Line
+	}
+	return start, lastLine(l.node)
+}
+
+// ranges returns the source ranges of the location, for BuildKit.
+func (l location) ranges() []*pb.Range {
+	start, end := l.lines()
+	if start == 0 {
+		return nil
+	}
+	return []*pb.Range{{
+		Start: &pb.Position{Line: int32(start)},
+		End:   &pb.Position{Line: int32(end)},
+	}}
+}
+
+func (l location) String() string {
+	if l.resource == nil {
+		return ""
+	}
+	start, _ := l.lines()
+	if start == 0 {
+		return l.resource.Path
+	}
+	return l.resource.Path + ":" + strconv.Itoa(start)
+}
+
+// warn reports a warning on a location through the BuildKit warnings API, so
+// that clients print it (with its source) in their warnings summary. It is
+// logged if it can't be reported.
+func warn(ctx context.Context, c client.Client, l location, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if s := l.String(); s != "" {
+		msg += " (" + s + ")"
+	}
+	opts := client.WarnOpts{Level: 1}
+	info, dgst := l.sourceInfo(ctx)
+	if info != nil {
+		opts.SourceInfo = info
+		opts.Range = l.ranges()
+	}
+	if err := c.Warn(ctx, dgst, msg, opts); err != nil {
+		logrus.Warn(msg)
+	}
+}
+
+// wrapError attaches the location to an error, so that clients print it with
+// its source, like errors of the steps (see sourceLocation).
+func (l location) wrapError(ctx context.Context, err error) error {
+	if err == nil || l.resource == nil {
+		return err
+	}
+	err = errors.Wrap(err, l.String())
+	if info, _ := l.sourceInfo(ctx); info != nil {
+		err = errdefs.WithSource(err, &errdefs.Source{Info: info, Ranges: l.ranges()})
+	}
+	return err
+}
+
+// sourceLocation returns the option mapping an LLB operation (e.g. the exec
+// of a step) to the location, so that BuildKit prints its YAML source when it
+// fails.
+func (l location) sourceLocation() llb.ConstraintsOpt {
+	var sm *llb.SourceMap
+	if l.resource != nil {
+		sm = l.resource.SourceMap
+	}
+	return sm.Location(l.ranges())
+}
+
+// sourceInfo returns the source of the location file, with the digest of the
+// operation it was loaded with. It is nil without source map.
+func (l location) sourceInfo(ctx context.Context) (*pb.SourceInfo, digest.Digest) {
+	if l.resource == nil || l.resource.SourceMap == nil {
+		return nil, ""
+	}
+	sm := l.resource.SourceMap
+	def, err := sourceDefinition(ctx, sm)
+	if err != nil {
+		logrus.Debugf("No source for %s: %v", l.resource.Path, err)
+		return nil, ""
+	}
+	dgst, err := def.Head()
+	if err != nil {
+		logrus.Debugf("No source for %s: %v", l.resource.Path, err)
+		return nil, ""
+	}
+	return &pb.SourceInfo{
+		Filename:   sm.Filename,
+		Data:       sm.Data,
+		Language:   sm.Language,
+		Definition: def.ToPB(),
+	}, dgst
+}
+
+// sourceDefinition returns the definition of the state a source map was
+// loaded from, marshalling it once.
+func sourceDefinition(ctx context.Context, sm *llb.SourceMap) (*llb.Definition, error) {
+	if sm.Definition == nil {
+		def, err := sm.State.Marshal(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sm.Definition = def
+	}
+	return sm.Definition, nil
+}
+
+// yamlDocuments parses the documents of a YAML stream into nodes, to locate
+// objects in it. Parsed documents are cached by digest of the stream. A stream
+// that cannot be parsed has no documents.
+func yamlDocuments(s string) []*yaml.Node {
+	dgst := digest.FromString(s)
+	yamlCache.Lock()
+	defer yamlCache.Unlock()
+	if docs, ok := yamlCache.documents[dgst]; ok {
+		return docs
+	}
+	docs := []*yaml.Node{}
+	decoder := yaml.NewDecoder(strings.NewReader(s))
+	for {
+		doc := &yaml.Node{}
+		if err := decoder.Decode(doc); err != nil {
+			if err != io.EOF {
+				logrus.Debugf("Cannot locate objects: %v", err)
+			}
+			break
+		}
+		docs = append(docs, doc)
+	}
+	yamlCache.documents[dgst] = docs
+	return docs
+}
+
+var yamlCache = struct {
+	sync.Mutex
+	documents map[digest.Digest][]*yaml.Node
+}{documents: map[digest.Digest][]*yaml.Node{}}
+
+// mappingField returns the key and value nodes of a field of a mapping node.
+func mappingField(node *yaml.Node, name string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func mappingValue(node *yaml.Node, name string) *yaml.Node {
+	_, value := mappingField(node, name)
+	return value
+}
+
+func scalar(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// lastLine returns the last line of a node, including its children. Block
+// scalars (e.g. a script) span the lines of their value.
+func lastLine(node *yaml.Node) int {
+	line := node.Line
+	if node.Kind == yaml.ScalarNode && (node.Style&(yaml.LiteralStyle|yaml.FoldedStyle)) != 0 {
+		line += strings.Count(strings.TrimRight(node.Value, "\n"), "\n") + 1
+	}
+	for _, c := range node.Content {
+		if l := lastLine(c); l > line {
+			line = l
+		}
+	}
+	return line
+}
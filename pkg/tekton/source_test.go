@@ -0,0 +1,170 @@
+package tekton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/errdefs"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const locatedRuns = `apiVersion: tekton.dev/v1
+kind: TaskRun
+metadata:
+  generateName: build-
+spec:
+  retries: 2
+  taskSpec:
+    sidecars:
+    - name: db
+      image: postgres
+      ports:
+      - containerPort: 5432
+    steps:
+    - name: build
+      image: golang
+      imagePullPolicy: Always
+      script: |
+        go build ./...
+        go test ./...
+---
+apiVersion: tekton.dev/v1
+kind: PipelineRun
+metadata:
+  name: release
+spec:
+  pipelineSpec:
+    tasks:
+    - name: publish
+      retries: 3
+      taskRef:
+        name: publish
+  taskRunTemplate:
+    podTemplate:
+      nodeSelector:
+        kubernetes.io/os: linux
+`
+
+func TestLocation(t *testing.T) {
+	resource := &ContextResource{Path: "runs.yaml", Data: locatedRuns}
+	tr := locate(resource, "TaskRun", metav1.ObjectMeta{GenerateName: "build-"})
+	pr := locate(resource, "PipelineRun", metav1.ObjectMeta{Name: "release"})
+	for _, tc := range []struct {
+		loc        location
+		start, end int
+		expected   string
+	}{
+		{loc: tr, start: 1, end: 19, expected: "runs.yaml:1"},
+		{loc: tr.child("spec", "taskSpec", "steps", 0), start: 14, end: 19, expected: "runs.yaml:14"},
+		{loc: tr.child("spec", "taskSpec", "steps", 0, "imagePullPolicy"), start: 16, end: 16, expected: "runs.yaml:16"},
+		{loc: tr.child("spec", "taskSpec", "sidecars", 0, "ports"), start: 11, end: 12, expected: "runs.yaml:11"},
+		// Missing nodes are located at their closest parent
+		{loc: tr.child("spec", "taskSpec", "steps", 1, "image"), start: 13, end: 19, expected: "runs.yaml:13"},
+		{loc: pr.child("spec", "pipelineSpec", "tasks", 0, "retries"), start: 29, end: 29, expected: "runs.yaml:29"},
+		{loc: locate(resource, "Task", metav1.ObjectMeta{Name: "build"}), expected: ""},
+	} {
+		start, end := tc.loc.lines()
+		if start != tc.start || end != tc.end {
+			t.Errorf("%s: expected lines %d-%d, got %d-%d", tc.expected, tc.start, tc.end, start, end)
+		}
+		if s := tc.loc.String(); s != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, s)
+		}
+	}
+}
+
+func TestValidateWarnings(t *testing.T) {
+	ctx := context.Background()
+	resource := ContextResource{Path: "runs.yaml", Data: locatedRuns}
+	runs, err := readResources(resource, nil, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeClient{}
+	tr := runs[0].(TaskRun)
+	if err := validateTaskRun(ctx, c, tr.main, tr.locations[tr.main]); err != nil {
+		t.Fatal(err)
+	}
+	pr := runs[1].(PipelineRun)
+	if err := validatePipelineRun(ctx, c, pr.main, pr.locations[pr.main]); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"TaskRun build-1: retries is ignored (runs.yaml:6)",
+		"sidecar db: ports is ignored (runs.yaml:11)",
+		"step build: imagePullPolicy is ignored (runs.yaml:16)",
+		"PodTemplate: nodeSelector is ignored (runs.yaml:34)",
+		"task publish: retries is ignored (runs.yaml:29)",
+	}
+	if d := cmp.Diff(expected, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+
+	// Without location, warnings are still reported
+	c = &fakeClient{}
+	if err := validateTaskSpec(ctx, c, v1.TaskSpec{Steps: []v1.Step{{Name: "run", ImagePullPolicy: "Never"}}}, location{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"step run: imagePullPolicy is ignored"}, c.warnings); d != "" {
+		t.Errorf("warnings mismatch %s", diff.PrintWantGot(d))
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	ctx := context.Background()
+	src := llb.Local("dockerfile")
+	resource := &ContextResource{
+		Path:      "runs.yaml",
+		Data:      locatedRuns,
+		SourceMap: llb.NewSourceMap(&src, "runs.yaml", "YAML", []byte(locatedRuns)),
+	}
+	loc := locate(resource, "TaskRun", metav1.ObjectMeta{GenerateName: "build-"}).child("spec", "taskSpec")
+
+	st := llb.Image("golang").Run(llb.Shlex("go build ./..."), loc.child("steps", 0).sourceLocation()).Root()
+	def, err := st.Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Source == nil || len(def.Source.Infos) != 1 || def.Source.Infos[0].Filename != "runs.yaml" {
+		t.Fatalf("expected runs.yaml source, got %+v", def.Source)
+	}
+	var ranges []string
+	for _, locs := range def.Source.Locations {
+		for _, l := range locs.Locations {
+			for _, r := range l.Ranges {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", r.Start.Line, r.End.Line))
+			}
+		}
+	}
+	if d := cmp.Diff([]string{"14-19"}, ranges); d != "" {
+		t.Errorf("ranges mismatch %s", diff.PrintWantGot(d))
+	}
+
+	// Errors carry the same source
+	err = loc.child("sidecars", 0, "ports").wrapError(ctx, errors.New("unsupported"))
+	if err.Error() != "runs.yaml:11: unsupported" {
+		t.Errorf("unexpected error %q", err)
+	}
+	sources := errdefs.Sources(err)
+	if len(sources) != 1 || sources[0].Info.Filename != "runs.yaml" || sources[0].Ranges[0].Start.Line != 11 {
+		t.Errorf("unexpected sources %+v", sources)
+	}
+	// Without location, errors are left as is
+	if err := (location{}).wrapError(ctx, errors.New("unsupported")); err.Error() != "unsupported" || len(errdefs.Sources(err)) != 0 {
+		t.Errorf("unexpected error %q", err)
+	}
+	// Without source map, steps are not mapped
+	def, err = llb.Image("golang").Run(llb.Shlex("true"), location{}.sourceLocation()).Root().Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Source != nil && len(def.Source.Infos) > 0 {
+		t.Errorf("unexpected source %+v", def.Source)
+	}
+}
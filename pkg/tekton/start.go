@@ -0,0 +1,222 @@
+package tekton
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8scheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+// LookupTask returns the Task named name from the given resources (e.g. the
+// YAML files of a directory). Files that cannot be decoded are skipped.
+func LookupTask(resources []ContextResource, name string) (*v1.Task, error) {
+	objs, err := lookupObjects(resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if t, ok := obj.(*v1.Task); ok && t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, errors.Errorf("Task %s not found", name)
+}
+
+// LookupPipeline returns the Pipeline named name from the given resources
+// (e.g. the YAML files of a directory). Files that cannot be decoded are
+// skipped.
+func LookupPipeline(resources []ContextResource, name string) (*v1.Pipeline, error) {
+	objs, err := lookupObjects(resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if p, ok := obj.(*v1.Pipeline); ok && p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, errors.Errorf("Pipeline %s not found", name)
+}
+
+func lookupObjects(resources []ContextResource) ([]interface{}, error) {
+	if err := addToScheme(k8scheme.Scheme); err != nil {
+		return nil, err
+	}
+	objs := []interface{}{}
+	for _, r := range resources {
+		o, err := parseDocuments(r.Data)
+		if err != nil {
+			logrus.Debugf("Skipping %s: %v", r.Path, err)
+			continue
+		}
+		objs = append(objs, o...)
+	}
+	return objs, nil
+}
+
+// NewTaskRun returns a TaskRun of the Task named name, like tkn task start.
+func NewTaskRun(name string, params v1.Params, workspaces []v1.WorkspaceBinding) *v1.TaskRun {
+	return &v1.TaskRun{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "TaskRun",
+		},
+		ObjectMeta: metav1.ObjectMeta{GenerateName: name + "-run-"},
+		Spec: v1.TaskRunSpec{
+			TaskRef:    &v1.TaskRef{Name: name},
+			Params:     params,
+			Workspaces: workspaces,
+		},
+	}
+}
+
+// NewPipelineRun returns a PipelineRun of the Pipeline named name, like tkn
+// pipeline start.
+func NewPipelineRun(name string, params v1.Params, workspaces []v1.WorkspaceBinding) *v1.PipelineRun {
+	return &v1.PipelineRun{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "PipelineRun",
+		},
+		ObjectMeta: metav1.ObjectMeta{GenerateName: name + "-run-"},
+		Spec: v1.PipelineRunSpec{
+			PipelineRef: &v1.PipelineRef{Name: name},
+			Params:      params,
+			Workspaces:  workspaces,
+		},
+	}
+}
+
+// StartParams parses the params of a Task or Pipeline given as name=value,
+// like tkn: array values are separated by commas, object values are key:value
+// pairs separated by commas. Params not given use their default with
+// useDefaults, params without default are always required.
+func StartParams(specs v1.ParamSpecs, values []string, useDefaults bool) (v1.Params, error) {
+	types := paramTypes(specs)
+	given := map[string]bool{}
+	params := v1.Params{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid param %q, expected name=value", value)
+		}
+		name, raw := parts[0], parts[1]
+		t, ok := types[name]
+		if !ok {
+			return nil, errors.Errorf("param %s is not declared", name)
+		}
+		v, err := paramValue(t, raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "param %s", name)
+		}
+		given[name] = true
+		params = append(params, v1.Param{Name: name, Value: v})
+	}
+	for _, spec := range specs {
+		if given[spec.Name] {
+			continue
+		}
+		if spec.Default == nil {
+			return nil, errors.Errorf("param %s is required, use -p %s=<value>", spec.Name, spec.Name)
+		}
+		if !useDefaults {
+			return nil, errors.Errorf("param %s is not set, use -p %s=<value> or --use-param-defaults", spec.Name, spec.Name)
+		}
+	}
+	return params, nil
+}
+
+// StartWorkspace is a workspace of a Task or Pipeline given like tkn does,
+// with its binding. Local is the host directory bound to it, if any, to be
+// shared by the client as a local context.
+type StartWorkspace struct {
+	Binding v1.WorkspaceBinding
+	Local   string
+}
+
+// StartWorkspaces parses the workspaces of a Task or Pipeline (declared) given
+// like tkn does, as comma-separated key=value: name=<name> with emptyDir=,
+// config=<configmap>, secret=<secret>, claimName=<pvc> or local=<dir> (and
+// optionally item=<key>=<path> and subPath=<path>).
+func StartWorkspaces(declared []string, values []string) ([]StartWorkspace, error) {
+	known := make(map[string]bool, len(declared))
+	for _, d := range declared {
+		known[d] = true
+	}
+	workspaces := []StartWorkspace{}
+	for _, value := range values {
+		w, err := parseStartWorkspace(value)
+		if err != nil {
+			return nil, err
+		}
+		if !known[w.Binding.Name] {
+			return nil, errors.Errorf("workspace %s is not declared", w.Binding.Name)
+		}
+		workspaces = append(workspaces, w)
+	}
+	return workspaces, nil
+}
+
+func parseStartWorkspace(value string) (StartWorkspace, error) {
+	w := StartWorkspace{}
+	items := []corev1.KeyToPath{}
+	sources := 0
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return w, errors.Errorf("invalid workspace %q, expected key=value", value)
+		}
+		switch k, v := kv[0], kv[1]; k {
+		case "name":
+			w.Binding.Name = v
+		case "subPath":
+			w.Binding.SubPath = v
+		case "emptyDir":
+			w.Binding.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			sources++
+		case "config":
+			w.Binding.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: v}}
+			sources++
+		case "secret":
+			w.Binding.Secret = &corev1.SecretVolumeSource{SecretName: v}
+			sources++
+		case "claimName":
+			w.Binding.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v}
+			sources++
+		case "local":
+			// Bound to an emptyDir placeholder, overridden with the local context
+			w.Binding.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			w.Local = v
+			sources++
+		case "item":
+			item := strings.SplitN(v, "=", 2)
+			if len(item) != 2 {
+				return w, errors.Errorf("invalid workspace item %q, expected key=path", v)
+			}
+			items = append(items, corev1.KeyToPath{Key: item[0], Path: item[1]})
+		default:
+			return w, errors.Errorf("invalid workspace %q: unknown key %s", value, k)
+		}
+	}
+	if w.Binding.Name == "" {
+		return w, errors.Errorf("invalid workspace %q: name is required", value)
+	}
+	if sources != 1 {
+		return w, errors.Errorf("workspace %s: exactly one of emptyDir, config, secret, claimName or local is required", w.Binding.Name)
+	}
+	if len(items) > 0 {
+		switch {
+		case w.Binding.ConfigMap != nil:
+			w.Binding.ConfigMap.Items = items
+		case w.Binding.Secret != nil:
+			w.Binding.Secret.Items = items
+		default:
+			return w, errors.Errorf("workspace %s: item is only supported with config or secret", w.Binding.Name)
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,139 @@
+package tekton
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/test/diff"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestLookupTask(t *testing.T) {
+	resources := []ContextResource{{
+		Path: "values.yaml",
+		Data: "image: [unclosed",
+	}, {
+		Path: "tasks.yaml",
+		Data: `apiVersion: tekton.dev/v1
+kind: Pipeline
+metadata:
+  name: build
+---
+apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: build
+spec:
+  params:
+  - name: version
+`,
+	}}
+	task, err := LookupTask(resources, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Spec.Params) != 1 {
+		t.Errorf("unexpected task: %+v", task)
+	}
+	if _, err := LookupPipeline(resources, "build"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupTask(resources, "unknown"); err == nil {
+		t.Errorf("expected an error for an unknown task")
+	}
+}
+
+func TestStartParams(t *testing.T) {
+	specs := v1.ParamSpecs{
+		{Name: "string"},
+		{Name: "array", Type: v1.ParamTypeArray},
+		{Name: "object", Type: v1.ParamTypeObject},
+		{Name: "defaulted", Default: v1.NewStructuredValues("a", "b")},
+	}
+	params, err := StartParams(specs, []string{"string=a=b", "array=x,y", "object=k1:v1, k2:v2"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := v1.Params{
+		{Name: "string", Value: v1.ParamValue{Type: v1.ParamTypeString, StringVal: "a=b"}},
+		{Name: "array", Value: v1.ParamValue{Type: v1.ParamTypeArray, ArrayVal: []string{"x", "y"}}},
+		{Name: "object", Value: v1.ParamValue{Type: v1.ParamTypeObject, ObjectVal: map[string]string{"k1": "v1", "k2": "v2"}}},
+	}
+	if d := cmp.Diff(expected, params); d != "" {
+		t.Errorf("params mismatch %s", diff.PrintWantGot(d))
+	}
+
+	for _, tc := range []struct {
+		values      []string
+		useDefaults bool
+	}{
+		{values: []string{"string=a", "array=x", "object=k:v"}},
+		{values: []string{"array=x", "object=k:v"}, useDefaults: true},
+		{values: []string{"string=a", "array=x", "object=k:v", "unknown=a"}, useDefaults: true},
+		{values: []string{"string=a", "array=x", "object=invalid"}, useDefaults: true},
+		{values: []string{"string"}, useDefaults: true},
+	} {
+		if _, err := StartParams(specs, tc.values, tc.useDefaults); err == nil {
+			t.Errorf("expected an error for %v (use defaults %v)", tc.values, tc.useDefaults)
+		}
+	}
+}
+
+func TestStartWorkspaces(t *testing.T) {
+	workspaces, err := StartWorkspaces([]string{"source", "config", "cache"}, []string{
+		"name=source,local=.",
+		"name=config,config=settings,item=key=path",
+		"name=cache,emptyDir=,subPath=go",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StartWorkspace{{
+		Binding: v1.WorkspaceBinding{Name: "source", EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		Local:   ".",
+	}, {
+		Binding: v1.WorkspaceBinding{Name: "config", ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "settings"},
+			Items:                []corev1.KeyToPath{{Key: "key", Path: "path"}},
+		}},
+	}, {
+		Binding: v1.WorkspaceBinding{Name: "cache", SubPath: "go", EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}}
+	if d := cmp.Diff(expected, workspaces); d != "" {
+		t.Errorf("workspaces mismatch %s", diff.PrintWantGot(d))
+	}
+
+	for _, invalid := range []string{
+		"name=unknown,emptyDir=",
+		"name=source",
+		"name=source,emptyDir=,secret=s",
+		"name=source,emptyDir=,item=k=p",
+		"emptyDir=",
+		"name=source,foo=bar",
+	} {
+		if _, err := StartWorkspaces([]string{"source"}, []string{invalid}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestNewTaskRun(t *testing.T) {
+	tr := NewTaskRun("build", v1.Params{{Name: "version", Value: *v1.NewStructuredValues("1.0")}}, nil)
+	content, err := yaml.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := readResources(ContextResource{Path: "run.yaml", Data: string(content)}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, ok := runs[0].(TaskRun)
+	if !ok {
+		t.Fatalf("expected a TaskRun, got %T", runs[0])
+	}
+	if run.main.GenerateName != "build-run-" || run.main.Spec.TaskRef.Name != "build" || len(run.main.Spec.Params) != 1 {
+		t.Errorf("unexpected TaskRun: %+v", run.main)
+	}
+}
@@ -0,0 +1,128 @@
+package tekton
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/tektoncd/pipeline/pkg/container"
+)
+
+// resolveStepActions replaces the steps of a Task referencing a StepAction
+// (by name, from the context) with the StepAction, merged the same way the
+// Tekton reconciler does. Step params are substituted in the StepAction, Task
+// params they refer to are substituted later with the rest of the Task.
+func resolveStepActions(ctx context.Context, ts v1.TaskSpec, stepActions map[string]*v1beta1.StepAction) (v1.TaskSpec, error) {
+	steps := make([]v1.Step, len(ts.Steps))
+	for i, step := range ts.Steps {
+		if step.Ref == nil {
+			steps[i] = step
+			continue
+		}
+		resolved, err := resolveStepAction(ctx, step, stepActions)
+		if err != nil {
+			return ts, errors.Wrapf(err, "step %s", step.Name)
+		}
+		steps[i] = *resolved
+	}
+	ts.Steps = steps
+	return ts, nil
+}
+
+func resolveStepAction(ctx context.Context, step v1.Step, stepActions map[string]*v1beta1.StepAction) (*v1.Step, error) {
+	if step.Ref.Resolver != "" {
+		return nil, errors.Errorf("StepAction resolver %s not supported", step.Ref.Resolver)
+	}
+	stepAction, ok := stepActions[step.Ref.Name]
+	if !ok {
+		return nil, errors.Errorf("StepAction %s not found in context", step.Ref.Name)
+	}
+	spec := stepAction.StepActionSpec()
+	spec.SetDefaults(ctx)
+
+	stringReplacements, arrayReplacements, err := stepActionReplacements(step.Params, spec.Params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "StepAction %s", step.Ref.Name)
+	}
+	fromStepAction := spec.ToStep()
+	container.ApplyStepReplacements(fromStepAction, stringReplacements, arrayReplacements)
+
+	// Merge the StepAction into the step, like Tekton does
+	resolved := step.DeepCopy()
+	resolved.Image = fromStepAction.Image
+	resolved.SecurityContext = fromStepAction.SecurityContext
+	if len(fromStepAction.Command) > 0 {
+		resolved.Command = fromStepAction.Command
+	}
+	if len(fromStepAction.Args) > 0 {
+		resolved.Args = fromStepAction.Args
+	}
+	if fromStepAction.Script != "" {
+		resolved.Script = fromStepAction.Script
+	}
+	resolved.WorkingDir = fromStepAction.WorkingDir
+	if fromStepAction.Env != nil {
+		resolved.Env = fromStepAction.Env
+	}
+	if len(fromStepAction.VolumeMounts) > 0 {
+		resolved.VolumeMounts = fromStepAction.VolumeMounts
+	}
+	if len(fromStepAction.Results) > 0 {
+		resolved.Results = fromStepAction.Results
+	}
+	resolved.Ref = nil
+	resolved.Params = nil
+	return resolved, nil
+}
+
+// stepActionReplacements returns the $(params.<name>) replacements of a
+// StepAction, from the step params or the StepAction defaults.
+func stepActionReplacements(params v1.Params, specs v1.ParamSpecs) (map[string]string, map[string][]string, error) {
+	values := map[string]v1.ParamValue{}
+	for _, p := range params {
+		values[p.Name] = p.Value
+	}
+	stringReplacements := map[string]string{}
+	arrayReplacements := map[string][]string{}
+	declared := map[string]bool{}
+	for _, spec := range specs {
+		declared[spec.Name] = true
+		value, ok := values[spec.Name]
+		if !ok {
+			if spec.Default == nil {
+				return nil, nil, errors.Errorf("param %s is required", spec.Name)
+			}
+			value = *spec.Default
+		}
+		for _, key := range paramKeys(spec.Name) {
+			switch value.Type {
+			case v1.ParamTypeArray:
+				arrayReplacements[key] = value.ArrayVal
+			case v1.ParamTypeObject:
+				for k, v := range value.ObjectVal {
+					stringReplacements[key+"."+k] = v
+				}
+			default:
+				stringReplacements[key] = value.StringVal
+			}
+		}
+	}
+	for _, p := range params {
+		if !declared[p.Name] {
+			return nil, nil, errors.Errorf("param %s is not declared", p.Name)
+		}
+	}
+	return stringReplacements, arrayReplacements, nil
+}
+
+// paramKeys returns the replacement keys of a param, in all the supported
+// notations (params.name, params['name'] and params["name"]).
+func paramKeys(name string) []string {
+	return []string{
+		fmt.Sprintf("params.%s", name),
+		fmt.Sprintf("params['%s']", name),
+		fmt.Sprintf("params[%q]", name),
+	}
+}
@@ -0,0 +1,106 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveStepActions(t *testing.T) {
+	stepActions := map[string]*v1beta1.StepAction{
+		"clone": {
+			ObjectMeta: metav1.ObjectMeta{Name: "clone"},
+			Spec: v1beta1.StepActionSpec{
+				Image:   "alpine/git",
+				Command: []string{"git", "clone"},
+				Args:    []string{"$(params.url)", "$(params.flags[*])", "--depth=$(params.depth)"},
+				Params: v1.ParamSpecs{{
+					Name: "url",
+					Type: v1.ParamTypeString,
+				}, {
+					Name:    "flags",
+					Type:    v1.ParamTypeArray,
+					Default: v1.NewStructuredValues("--quiet", "--no-tags"),
+				}, {
+					Name:    "depth",
+					Type:    v1.ParamTypeString,
+					Default: v1.NewStructuredValues("1"),
+				}},
+			},
+		},
+	}
+	ts := v1.TaskSpec{Steps: []v1.Step{{
+		Name:  "inline",
+		Image: "alpine",
+	}, {
+		Name: "clone",
+		Ref:  &v1.Ref{Name: "clone"},
+		Params: v1.Params{{
+			Name:  "url",
+			Value: *v1.NewStructuredValues("$(params.repository)"),
+		}, {
+			Name:  "depth",
+			Value: *v1.NewStructuredValues("10"),
+		}},
+	}}}
+
+	got, err := resolveStepActions(context.Background(), ts, stepActions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := v1.Step{
+		Name:    "clone",
+		Image:   "alpine/git",
+		Command: []string{"git", "clone"},
+		Args:    []string{"$(params.repository)", "--quiet", "--no-tags", "--depth=10"},
+	}
+	if d := cmp.Diff(expected, got.Steps[1]); d != "" {
+		t.Errorf("unexpected resolved step: %s", d)
+	}
+	if d := cmp.Diff(ts.Steps[0], got.Steps[0]); d != "" {
+		t.Errorf("inline step should not change: %s", d)
+	}
+}
+
+func TestResolveStepActionsErrors(t *testing.T) {
+	stepActions := map[string]*v1beta1.StepAction{
+		"required": {
+			ObjectMeta: metav1.ObjectMeta{Name: "required"},
+			Spec: v1beta1.StepActionSpec{
+				Image:  "alpine",
+				Params: v1.ParamSpecs{{Name: "url", Type: v1.ParamTypeString}},
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		step v1.Step
+	}{{
+		name: "not found",
+		step: v1.Step{Name: "s", Ref: &v1.Ref{Name: "unknown"}},
+	}, {
+		name: "resolver",
+		step: v1.Step{Name: "s", Ref: &v1.Ref{ResolverRef: v1.ResolverRef{Resolver: "git"}}},
+	}, {
+		name: "missing param",
+		step: v1.Step{Name: "s", Ref: &v1.Ref{Name: "required"}},
+	}, {
+		name: "extra param",
+		step: v1.Step{Name: "s", Ref: &v1.Ref{Name: "required"}, Params: v1.Params{
+			{Name: "url", Value: *v1.NewStructuredValues("u")},
+			{Name: "extra", Value: *v1.NewStructuredValues("e")},
+		}},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := resolveStepActions(context.Background(), v1.TaskSpec{Steps: []v1.Step{tc.step}}, stepActions); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/distribution/reference"
@@ -11,14 +12,34 @@ import (
 	"github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
 	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/pod"
 	"github.com/tektoncd/pipeline/pkg/reconciler/taskrun/resources"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
 	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	defaultScriptPreamble = "#!/bin/sh\nset -e\n"
 	scriptsDir            = "/tekton/scripts"
+	binDir                = "/tekton/bin"
+	stepsDir              = "/tekton/steps"
+	entrypointBinary      = binDir + "/entrypoint"
+
+	// workspaceDir, homeDir, runDir and credsDir (and stepsDir) are shared by
+	// all the steps of a Task, like in a Tekton pod (see podDirs).
+	workspaceDir = "/workspace"
+	homeDir      = "/tekton/home"
+	runDir       = "/tekton/run"
+	credsDir     = "/tekton/creds"
+
+	// resultsDir is where the Task results are written
+	resultsDir = "/tekton/results"
+
+	// stepOutputDir is a writable mount used to chain the next steps after
+	// a step with a read-only root filesystem (which has no output).
+	stepOutputDir = "/tekton/.output"
 )
 
 type pstep struct {
@@ -28,68 +49,118 @@ type pstep struct {
 	runOptions   []llb.RunOption
 	workspaces   []mountOptionFn
 	volumeMounts []mountOptionFn
+	readOnlyRoot bool
 }
 
 type mountOptionFn func(llb.State) llb.RunOption
 
-// TaskRunToLLB converts a TaskRun into a BuildKit LLB State.
-func TaskRunToLLB(ctx context.Context, c client.Client, r TaskRun) (llb.State, error) {
+// TaskRunToLLB converts a TaskRun into a BuildKit LLB State, and its Task if
+// it has sidecars to run alongside it.
+func TaskRunToLLB(ctx context.Context, c client.Client, r TaskRun) (llb.State, []TaskSidecars, error) {
 	var err error
 	tr := r.main
+	bindings, contexts, err := overrideWorkspaces(tr.Spec.Workspaces, config.FromContext(ctx).Workspaces)
+	if err != nil {
+		return llb.State{}, nil, err
+	}
+	tr.Spec.Workspaces = bindings
 	// Validation
-	if err = validateTaskRun(ctx, tr); err != nil {
-		return llb.State{}, err
+	if err = validateTaskRun(ctx, c, tr, r.locations[tr]); err != nil {
+		return llb.State{}, nil, err
+	}
+	if err = validateCoschedule(ctx, tr.Spec.Workspaces); err != nil {
+		return llb.State{}, nil, err
+	}
+	pullSecrets := imagePullSecrets(ctx, tr.Spec.ServiceAccountName, tr.Spec.PodTemplate, r.serviceAccounts)
+	pullSecretsLoc := r.locations[tr].child("spec", "podTemplate", "imagePullSecrets")
+	if err = registerImagePullSecrets(ctx, c, pullSecretsLoc, pullSecrets, r.secrets); err != nil {
+		return llb.State{}, nil, err
 	}
 
 	var ts *v1.TaskSpec
 	var name string
+	var taskLoc location
 	if tr.Spec.TaskSpec != nil {
 		ts = tr.Spec.TaskSpec
 		name = "embedded"
+		taskLoc = r.locations[tr].child("spec", "taskSpec")
 		// FIXME: we need to support this
 		// } else if tr.Spec.TaskRef != nil && tr.Spec.TaskRef.Bundle != "" {
-		// 	resolvedTask, err := resolveTaskInBundle(ctx, c, *tr.Spec.TaskRef)
+		// 	resolvedTask, loc, err := resolveTaskInBundle(ctx, c, *tr.Spec.TaskRef)
 		// 	if err != nil {
-		// 		return llb.State{}, err
+		// 		return llb.State{}, nil, err
 		// 	}
 		// 	ts = &resolvedTask.Spec
+		// 	taskLoc = loc.child("spec")
 		// 	name = tr.Spec.TaskRef.Name
 	} else if tr.Spec.TaskRef != nil && tr.Spec.TaskRef.Name != "" {
 		t, ok := r.tasks[tr.Spec.TaskRef.Name]
 		if !ok {
-			return llb.State{}, errors.Errorf("Taskref %s not found in context", tr.Spec.TaskRef.Name)
+			return llb.State{}, nil, r.locations[tr].child("spec", "taskRef").wrapError(ctx, errors.Errorf("Taskref %s not found in context", tr.Spec.TaskRef.Name))
 		}
 		t.SetDefaults(ctx)
+		warnDeprecations(ctx, c, r.locations[t], "Task "+t.Name, t.Annotations)
+		taskLoc = r.locations[t].child("spec")
+		if err := validateTaskSpec(ctx, c, t.Spec, taskLoc); err != nil {
+			return llb.State{}, nil, errors.Wrapf(err, "task %s", t.Name)
+		}
 		ts = &t.Spec
 		name = tr.Spec.TaskRef.Name
 	}
 
+	if tr.Spec.Params, err = overrideParams(tr.Spec.Params, ts.Params, config.FromContext(ctx).Params); err != nil {
+		return llb.State{}, nil, err
+	}
+
+	resolvedSpec, err := resolveStepActions(ctx, *ts, r.stepActions)
+	if err != nil {
+		return llb.State{}, nil, err
+	}
+	ts = &resolvedSpec
+
 	// Interpolation
 	spec, err := applyTaskRunSubstitution(ctx, tr, ts, name)
 	if err != nil {
-		return llb.State{}, errors.Wrap(err, "variable interpolation failed")
+		return llb.State{}, nil, errors.Wrap(err, "variable interpolation failed")
+	}
+
+	spec, err = applyTaskRunOverrides(spec, tr.Spec.StepSpecs, tr.Spec.SidecarSpecs, tr.Spec.ComputeResources)
+	if err != nil {
+		return llb.State{}, nil, err
 	}
 
 	// Execution
-	workspaces := []mountOptionFn{}
-	for _, w := range tr.Spec.Workspaces {
-		workspaces = append(workspaces,
-			func(state llb.State) llb.RunOption {
-				return llb.AddMount(w.Name, state, llb.AsPersistentCacheDir(tr.Name+"/"+w.Name, llb.CacheMountShared))
-			},
-		)
+	boundWorkspaces, err := bindWorkspaces(tr.Spec.Workspaces, tr.Name, r.configs, r.secrets)
+	if err != nil {
+		return llb.State{}, nil, err
+	}
+	if err := bindContextWorkspaces(c, boundWorkspaces, tr.Spec.Workspaces, contexts, tr.Name); err != nil {
+		return llb.State{}, nil, err
 	}
-	steps, err := taskSpecToPSteps(ctx, c, spec, tr.Name, workspaces, nil, r.configs, r.secrets)
+	workspaces, err := taskWorkspaceMounts(spec.Workspaces, taskRunBoundWorkspaces(tr.Spec.Workspaces, boundWorkspaces))
 	if err != nil {
-		return llb.State{}, errors.Wrap(err, "couldn't translate TaskSpec to builtkit llb")
+		return llb.State{}, nil, err
+	}
+	meta := taskRunPodMetadata(tr, name)
+	steps, err := taskSpecToPSteps(ctx, c, spec, taskLoc, tr.Name, meta, workspaces, firstTimeout(tr.Spec.Timeout), r.configs, r.secrets)
+	if err != nil {
+		return llb.State{}, nil, errors.Wrap(err, "couldn't translate TaskSpec to builtkit llb")
+	}
+	sidecars, err := taskSpecToSidecars(ctx, c, spec, taskLoc, tr.Name, meta, r.configs, r.secrets)
+	if err != nil {
+		return llb.State{}, nil, errors.Wrap(err, "couldn't translate sidecars")
 	}
 
 	resultState := llb.Scratch()
 	stepStates, err := pstepToState(c, steps, resultState, []llb.RunOption{})
 	if err != nil {
-		return llb.State{}, err
+		return llb.State{}, nil, err
+	}
+	st := stepStates[len(stepStates)-1]
+	if len(sidecars) == 0 {
+		return st, nil, nil
 	}
-	return stepStates[len(stepStates)-1], nil
+	return st, []TaskSidecars{{name: tr.Name, state: st, sidecars: sidecars}}, nil
 }
 
 func applyTaskRunSubstitution(ctx context.Context, tr *v1.TaskRun, ts *v1.TaskSpec, taskName string) (v1.TaskSpec, error) {
@@ -127,7 +198,44 @@ func applyTaskRunSubstitution(ctx context.Context, tr *v1.TaskRun, ts *v1.TaskSp
 	return *ts, nil
 }
 
-func taskSpecToPSteps(ctx context.Context, c client.Client, t v1.TaskSpec, name string, workspaces []mountOptionFn, taskTimeout *time.Duration, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) ([]pstep, error) {
+// applyTaskRunOverrides applies the stepSpecs, sidecarSpecs and task-level
+// computeResources of a run to a TaskSpec, like the Tekton reconciler does.
+// As steps run one after the other, each step gets the task-level limits.
+func applyTaskRunOverrides(ts v1.TaskSpec, stepSpecs []v1.TaskRunStepSpec, sidecarSpecs []v1.TaskRunSidecarSpec, computeResources *corev1.ResourceRequirements) (v1.TaskSpec, error) {
+	var err error
+	ts.Steps, err = v1.MergeStepsWithSpecs(ts.Steps, stepSpecs)
+	if err != nil {
+		return ts, errors.Wrap(err, "couldn't merge steps with stepSpecs")
+	}
+	ts.Sidecars, err = v1.MergeSidecarsWithSpecs(ts.Sidecars, sidecarSpecs)
+	if err != nil {
+		return ts, errors.Wrap(err, "couldn't merge sidecars with sidecarSpecs")
+	}
+	if computeResources != nil {
+		for i := range ts.Steps {
+			ts.Steps[i].ComputeResources = *computeResources.DeepCopy()
+		}
+	}
+	return ts, nil
+}
+
+// firstTimeout returns the first timeout set, nil if none is or if it is not
+// positive (which means no timeout, like in Tekton).
+func firstTimeout(timeouts ...*metav1.Duration) *time.Duration {
+	for _, t := range timeouts {
+		if t == nil {
+			continue
+		}
+		if t.Duration <= 0 {
+			return nil
+		}
+		d := t.Duration
+		return &d
+	}
+	return nil
+}
+
+func taskSpecToPSteps(ctx context.Context, c client.Client, t v1.TaskSpec, loc location, name string, meta podMetadata, workspaces []mountOptionFn, taskTimeout *time.Duration, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) ([]pstep, error) {
 	steps := make([]pstep, len(t.Steps))
 	cacheDirName := name + "/results"
 	mergedSteps, err := v1.MergeStepsWithStepTemplate(t.StepTemplate, t.Steps)
@@ -135,15 +243,16 @@ func taskSpecToPSteps(ctx context.Context, c client.Client, t v1.TaskSpec, name
 		return steps, errors.Wrap(err, "couldn't merge steps with StepTemplate")
 	}
 
-	// Build volume states map for emptyDir volumes
-	volumeStates := make(map[string]llb.State)
-	for _, vol := range t.Volumes {
-		if vol.EmptyDir != nil {
-			// Use a persistent cache for emptyDir to share data between steps
-			volumeStates[vol.Name] = llb.Scratch()
-		}
+	volumes, err := taskVolumes(c, t, name, meta, configs, secrets)
+	if err != nil {
+		return steps, err
+	}
+	podOptions, err := podTemplateRunOptions(meta.template)
+	if err != nil {
+		return steps, err
 	}
 
+	entrypointSt := llb.Image(config.FromContext(ctx).EntrypointImage, llb.WithMetaResolver(c))
 	for i, step := range mergedSteps {
 		ref, err := reference.ParseNormalizedNamed(step.Image)
 		if err != nil {
@@ -164,106 +273,142 @@ func taskSpecToPSteps(ctx context.Context, c client.Client, t v1.TaskSpec, name
 			stepTimeout = taskTimeout
 		}
 
+		stepLoc := loc.child("steps", i)
 		runOptions := []llb.RunOption{
 			llb.IgnoreCache,
 			llb.WithCustomName("[tekton] " + name + "/" + step.Name),
+			// Failures are reported with the step in the YAML source
+			stepLoc.sourceLocation(),
 		}
-		if step.Script != "" {
-			filename, scriptSt := files.Script(name+"/"+step.Name, fmt.Sprintf("script-%d", i), step.Script, continueOnError, stepTimeout)
-			scriptFile := filepath.Join(scriptsDir, filename)
+		if len(t.Sidecars) > 0 {
+			// Sidecars run on the host network (of the BuildKit worker), so
+			// do the steps to be able to reach them.
+			runOptions = append(runOptions, llb.With(llb.Network(llb.NetModeHost)))
+		}
+		// Like Tekton, HOME is shared by all the steps (unless the step
+		// sets it).
+		runOptions = append(runOptions, llb.AddEnv("HOME", homeDir))
+		// Resolve the environment first, as command and args may
+		// reference it using $(VAR).
+		env, err := stepEnv(step.EnvFrom, podTemplateEnv(meta.template, step.Env), meta, configs, secrets)
+		if err != nil {
+			return steps, errors.Wrapf(err, "step %s", step.Name)
+		}
+		for _, e := range env {
 			runOptions = append(runOptions,
-				llb.AddMount(scriptsDir, scriptSt, llb.SourcePath("/"), llb.Readonly),
-				llb.Args([]string{scriptFile}),
+				llb.AddEnv(e.name, e.value),
 			)
-		} else if (continueOnError || stepTimeout != nil) && len(step.Command) > 0 {
-			// For commands with OnError: continue or with timeout, wrap in a script
-			filename, scriptSt := files.CommandWrapper(name+"/"+step.Name, fmt.Sprintf("cmd-%d", i), step.Command, step.Args, continueOnError, stepTimeout)
-			scriptFile := filepath.Join(scriptsDir, filename)
+		}
+		var command []string
+		if step.Script != "" {
+			filename, scriptSt := files.Script(name+"/"+step.Name, fmt.Sprintf("script-%d", i), step.Script)
 			runOptions = append(runOptions,
 				llb.AddMount(scriptsDir, scriptSt, llb.SourcePath("/"), llb.Readonly),
-				llb.Args([]string{scriptFile}),
 			)
+			command = []string{filepath.Join(scriptsDir, filename)}
+		} else if len(step.Command) > 0 {
+			command = expandAll(append(append([]string{}, step.Command...), step.Args...), env)
 		} else {
-			runOptions = append(runOptions,
-				llb.Args(append(step.Command, step.Args...)),
+			// Like Kubernetes, use the image entrypoint (and cmd if there is no
+			// args) when no command is specified. Env and working directory
+			// from the image are inherited through the image state.
+			img, err := resolveImageConfig(ctx, c, ref.String())
+			if err != nil {
+				return steps, err
+			}
+			args := expandAll(step.Args, env)
+			if len(args) == 0 {
+				args = img.Cmd
+			}
+			command = append(append([]string{}, img.Entrypoint...), args...)
+			if img.User != "" {
+				runOptions = append(runOptions,
+					llb.With(llb.User(img.User)),
+				)
+			}
+		}
+		if len(command) == 0 {
+			return steps, errors.Errorf("step %s: no script, command or image entrypoint to run", step.Name)
+		}
+		// Run the step through the entrypoint binary, which handles
+		// timeout, onError, output redirection and the exit code file
+		// without relying on anything being present in the step image.
+		entrypointArgs := []string{
+			entrypointBinary,
+			"-step_metadata_dir", filepath.Join(stepsDir, pod.StepName(step.Name, i)),
+		}
+		if stepTimeout != nil {
+			entrypointArgs = append(entrypointArgs, "-timeout", stepTimeout.String())
+		}
+		if continueOnError {
+			entrypointArgs = append(entrypointArgs, "-on_error", string(v1.Continue))
+		}
+		// Output is teed to the configured files (e.g. a result path) and
+		// still streamed to the progress log.
+		if step.StdoutConfig != nil && step.StdoutConfig.Path != "" {
+			entrypointArgs = append(entrypointArgs, "-stdout_path", step.StdoutConfig.Path)
+		}
+		if step.StderrConfig != nil && step.StderrConfig.Path != "" {
+			entrypointArgs = append(entrypointArgs, "-stderr_path", step.StderrConfig.Path)
+		}
+		// Results are limited in size like with the configured results-from
+		// (results share the termination message, or each is limited with
+		// sidecar-logs).
+		featureFlags := config.FromContext(ctx).FeatureFlags
+		limitResults := len(t.Results) > 0 && featureFlags.MaxResultSize > 0
+		if i == 0 || limitResults {
+			entrypointArgs = append(entrypointArgs, "-results_dir", resultsDir)
+		}
+		if i == 0 {
+			// Like the emptyDir of a Tekton pod, the results of the Task
+			// start empty: the first step clears the results left in the
+			// (persistent) results cache by a previous run.
+			entrypointArgs = append(entrypointArgs, "-clear_results")
+		}
+		if limitResults {
+			entrypointArgs = append(entrypointArgs,
+				"-results_from", featureFlags.ResultExtractionMethod,
+				"-max_result_size", strconv.Itoa(featureFlags.MaxResultSize),
 			)
 		}
+		entrypointArgs = append(entrypointArgs, "--")
+		runOptions = append(runOptions,
+			llb.AddMount(binDir, entrypointSt, llb.SourcePath(binDir), llb.Readonly),
+			llb.Args(append(entrypointArgs, command...)),
+		)
 		if step.WorkingDir != "" {
 			runOptions = append(runOptions,
 				llb.With(llb.Dir(step.WorkingDir)),
 			)
 		}
-		if len(step.Env) > 0 {
-			for _, e := range step.Env {
-				runOptions = append(runOptions,
-					llb.AddEnv(e.Name, e.Value),
-				)
-			}
+		// The PodTemplate applies to all the steps, the step securityContext
+		// taking precedence.
+		runOptions = append(runOptions, podOptions...)
+		var podSecurityContext *corev1.PodSecurityContext
+		if meta.template != nil {
+			podSecurityContext = meta.template.SecurityContext
 		}
-		// Handle EnvFrom - load environment variables from ConfigMaps/Secrets
-		for _, envFrom := range step.EnvFrom {
-			prefix := envFrom.Prefix
-			if envFrom.ConfigMapRef != nil {
-				cm, ok := configs[envFrom.ConfigMapRef.Name]
-				if ok && cm != nil {
-					for k, v := range cm.Data {
-						runOptions = append(runOptions,
-							llb.AddEnv(prefix+k, v),
-						)
-					}
-				}
-				// If ConfigMap not found and it's not optional, we could error
-				// For now, silently skip if not found (similar to how Kubernetes handles optional refs)
-			}
-			if envFrom.SecretRef != nil {
-				sec, ok := secrets[envFrom.SecretRef.Name]
-				if ok && sec != nil {
-					for k, v := range sec.Data {
-						runOptions = append(runOptions,
-							llb.AddEnv(prefix+k, string(v)),
-						)
-					}
-				}
-				// If Secret not found and it's not optional, we could error
-				// For now, silently skip if not found
-			}
-		}
-		if step.SecurityContext != nil {
-			if step.SecurityContext.RunAsUser != nil {
-				user := fmt.Sprintf("%d", *step.SecurityContext.RunAsUser)
-				runOptions = append(runOptions,
-					llb.With(llb.User(user)),
-				)
-			}
+		if user := containerUser(ctx, c, stepLoc, "step "+step.Name, podSecurityContext, step.SecurityContext); user != "" {
+			runOptions = append(runOptions,
+				llb.With(llb.User(user)),
+			)
 		}
+		runOptions = append(runOptions, securityContextRunOptions(ctx, c, stepLoc, "step "+step.Name, step.SecurityContext)...)
+		runOptions = append(runOptions, computeResourcesRunOptions(ctx, c, stepLoc, name, step)...)
 		results := []mountOptionFn{
 			func(state llb.State) llb.RunOption {
-				return llb.AddMount("/tekton/results", state, llb.AsPersistentCacheDir(cacheDirName, llb.CacheMountShared))
+				return llb.AddMount(resultsDir, state, llb.AsPersistentCacheDir(cacheDirName, llb.CacheMountShared))
 			},
 		}
 
 		// Handle VolumeMounts
 		volumeMounts := []mountOptionFn{}
 		for _, vm := range step.VolumeMounts {
-			volName := vm.Name
-			mountPath := vm.MountPath
-			subPath := vm.SubPath
-			readOnly := vm.ReadOnly
-
-			if _, ok := volumeStates[volName]; ok {
-				volumeMounts = append(volumeMounts, func(state llb.State) llb.RunOption {
-					opts := []llb.MountOption{
-						llb.AsPersistentCacheDir(name+"/volume/"+volName, llb.CacheMountShared),
-					}
-					if subPath != "" {
-						opts = append(opts, llb.SourcePath(subPath))
-					}
-					if readOnly {
-						opts = append(opts, llb.Readonly)
-					}
-					return llb.AddMount(mountPath, state, opts...)
-				})
+			fn, ok := volumes[vm.Name]
+			if !ok {
+				return steps, errors.Errorf("step %s: volume %s not found", step.Name, vm.Name)
 			}
+			volumeMounts = append(volumeMounts, fn(vm.MountPath, vm.SubPath, vm.ReadOnly))
 		}
 
 		steps[i] = pstep{
@@ -273,18 +418,31 @@ func taskSpecToPSteps(ctx context.Context, c client.Client, t v1.TaskSpec, name
 			results:      results,
 			workspaces:   workspaces,
 			volumeMounts: volumeMounts,
+			readOnlyRoot: readOnlyRootFilesystem(step.SecurityContext),
 		}
 	}
 	return steps, nil
 }
 
+// podDirs are the directories shared by all the steps of a Task, emulating
+// the emptyDirs of a Tekton pod: the first step gets them empty, each next
+// step gets them as left by the previous one (see pstepToState).
+var podDirs = []string{workspaceDir, homeDir, runDir, stepsDir, credsDir}
+
 func pstepToState(c client.Client, steps []pstep, resultState llb.State, additionnalMounts []llb.RunOption) ([]llb.State, error) {
 	stepStates := make([]llb.State, len(steps))
+	podStates := make([]llb.State, len(podDirs))
+	for i := range podDirs {
+		podStates[i] = llb.Scratch()
+	}
 	for i, step := range steps {
 		runOptions := step.runOptions
-		mounts := make([]llb.RunOption, len(step.results))
-		for i, r := range step.results {
-			mounts[i] = r(resultState)
+		mounts := make([]llb.RunOption, 0, len(podDirs)+len(step.results))
+		for j, dir := range podDirs {
+			mounts = append(mounts, llb.AddMount(dir, podStates[j]))
+		}
+		for _, r := range step.results {
+			mounts = append(mounts, r(resultState))
 		}
 		// If not the first step, we need to create the chain to execute things in sequence
 		if i > 0 {
@@ -303,62 +461,100 @@ func pstepToState(c client.Client, steps []pstep, resultState llb.State, additio
 		}
 		runOptions = append(runOptions, mounts...)
 		runOptions = append(runOptions, additionnalMounts...)
-		state := llb.
+		exec := llb.
 			Image(step.image, llb.WithMetaResolver(c)).
-			Run(runOptions...).
-			Root()
-		stepStates[i] = state
+			Run(runOptions...)
+		for j, dir := range podDirs {
+			podStates[j] = exec.GetMount(dir)
+		}
+		if step.readOnlyRoot {
+			stepStates[i] = exec.AddMount(stepOutputDir, llb.Scratch())
+		} else {
+			stepStates[i] = exec.Root()
+		}
 	}
 	return stepStates, nil
 }
 
-func validateTaskRun(ctx context.Context, tr *v1.TaskRun) error {
+func validateTaskRun(ctx context.Context, c client.Client, tr *v1.TaskRun, loc location) error {
 	if tr.Name == "" && tr.GenerateName != "" {
 		tr.Name = tr.GenerateName + "generated"
 	}
 	tr.SetDefaults(ctx)
 	if err := tr.Validate(ctx); err != nil {
-		return errors.Wrapf(err, "validation failed for Taskrun %s", tr.Name)
+		return loc.wrapError(ctx, errors.Wrapf(err, "validation failed for Taskrun %s", tr.Name))
 	}
-	if tr.Spec.PodTemplate != nil {
-		return errors.New("PodTemplate not supported")
+	warnDeprecations(ctx, c, loc, "TaskRun "+tr.Name, tr.Annotations)
+	if err := validatePodTemplate(ctx, c, tr.Spec.PodTemplate, loc.child("spec", "podTemplate")); err != nil {
+		return loc.child("spec", "podTemplate").wrapError(ctx, err)
 	}
+	// ServiceAccountName is used to look up imagePullSecrets
+	warnIgnoredFields(ctx, c, loc.child("spec"), "TaskRun "+tr.Name, []ignoredField{
+		{"debug", tr.Spec.Debug != nil},
+		{"retries", tr.Spec.Retries > 0},
+		{"status", tr.Spec.Status != ""},
+		{"statusMessage", tr.Spec.StatusMessage != ""},
+	})
 	if tr.Spec.TaskSpec != nil {
-		return validateTaskSpec(ctx, *tr.Spec.TaskSpec)
+		return validateTaskSpec(ctx, c, *tr.Spec.TaskSpec, loc.child("spec", "taskSpec"))
 	}
 	return nil
 }
 
-func validateTaskSpec(ctx context.Context, t v1.TaskSpec) error {
-	if len(t.Sidecars) > 0 {
-		return errors.New("Sidecars are not supported")
-	}
-	// Volumes are now supported (emptyDir only for now)
-	for _, vol := range t.Volumes {
-		if vol.EmptyDir == nil {
-			return errors.Errorf("Volume %s: only emptyDir volumes are supported", vol.Name)
+func validateTaskSpec(ctx context.Context, c client.Client, t v1.TaskSpec, loc location) error {
+	// Sidecars are supported through gateway containers
+	for i, s := range t.Sidecars {
+		if len(s.VolumeMounts) > 0 {
+			return loc.child("sidecars", i, "volumeMounts").wrapError(ctx, errors.Errorf("Sidecar %s: VolumeMounts not supported", s.Name))
 		}
+		if len(s.Workspaces) > 0 {
+			return loc.child("sidecars", i, "workspaces").wrapError(ctx, errors.Errorf("Sidecar %s: Workspaces not supported", s.Name))
+		}
+		// ReadinessProbe is supported, polled before the steps run
+		warnIgnoredFields(ctx, c, loc.child("sidecars", i), "sidecar "+s.Name, []ignoredField{
+			{"ports", len(s.Ports) > 0},
+			{"livenessProbe", s.LivenessProbe != nil},
+			{"startupProbe", s.StartupProbe != nil},
+			{"lifecycle", s.Lifecycle != nil},
+			{"terminationMessagePath", s.TerminationMessagePath != ""},
+			{"terminationMessagePolicy", s.TerminationMessagePolicy != ""},
+			{"imagePullPolicy", s.ImagePullPolicy != ""},
+			{"stdin", s.Stdin},
+			{"stdinOnce", s.StdinOnce},
+			{"tty", s.TTY},
+		})
 	}
+	// Volumes are now supported (emptyDir, configMap, secret, downwardAPI and hostPath)
 	for i, s := range t.Steps {
 		// Step Timeout is now supported (wrapped with timeout command)
 		// OnError is now supported (continue and stopAndFail)
 		// EnvFrom is now supported (load env vars from ConfigMaps/Secrets)
 		// VolumeMounts are now supported
 		if len(s.VolumeDevices) > 0 {
-			return errors.Errorf("Step %d: VolumeDevices not supported", i)
-		}
-		// Silently ignore Ports
-		// Silently ignore LivenessProbe
-		// Silently ignore ReadinessProbe
-		// Silently ignore StartupProbe
-		// Silently ignore Lifecycle
-		// Silently ignore TerminationMessagePath
-		// Silently ignore TerminationMessagePolicy
-		// Silently ignore Resources (for now)
-		// Silently ignore ImagePullPolicy
-		// Silently ignore Stdin
-		// Silently ignore StdinOnce
-		// Silently ignore TTY
+			return loc.child("steps", i, "volumeDevices").wrapError(ctx, errors.Errorf("Step %d: VolumeDevices not supported", i))
+		}
+		// ComputeResources are enforced through the cgroup parent (best-effort)
+		// Images are pulled by BuildKit, following its own policy
+		warnIgnoredFields(ctx, c, loc.child("steps", i), "step "+s.Name, []ignoredField{
+			{"imagePullPolicy", s.ImagePullPolicy != ""},
+		})
 	}
 	return nil
 }
+
+// ignoredField is a field that is not supported locally, and ignored (with a
+// warning) when set.
+type ignoredField struct {
+	name string
+	set  bool
+}
+
+// warnIgnoredFields reports the ignored fields that are set, at their
+// location under loc.
+func warnIgnoredFields(ctx context.Context, c client.Client, loc location, what string, fields []ignoredField) {
+	for _, f := range fields {
+		if f.set {
+			warn(ctx, c, loc.child(f.name), "%s: %s is ignored", what, f.name)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package tekton
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeClient is a gateway client only able to resolve image configs (and
+// return build options and record warnings).
+type fakeClient struct {
+	client.Client
+	image    ocispecs.Image
+	opts     map[string]string
+	warnings []string
+}
+
+func (f *fakeClient) ResolveImageConfig(_ context.Context, ref string, _ sourceresolver.Opt) (string, digest.Digest, []byte, error) {
+	dt, err := json.Marshal(f.image)
+	return ref, "", dt, err
+}
+
+func (f *fakeClient) BuildOpts() client.BuildOpts {
+	return client.BuildOpts{Opts: f.opts}
+}
+
+func (f *fakeClient) Warn(_ context.Context, _ digest.Digest, msg string, _ client.WarnOpts) error {
+	f.warnings = append(f.warnings, msg)
+	return nil
+}
+
+// stepExecs returns the exec operations of the given steps, once marshalled.
+func stepExecs(t *testing.T, steps []pstep) []*pb.ExecOp {
+	t.Helper()
+	execs := []*pb.ExecOp{}
+	for _, step := range steps {
+		st := llb.Scratch().Run(step.runOptions...).Root()
+		def, err := st.Marshal(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dt := range def.Def {
+			var op pb.Op
+			if err := op.UnmarshalVT(dt); err != nil {
+				t.Fatal(err)
+			}
+			if exec := op.GetExec(); exec != nil {
+				execs = append(execs, exec)
+			}
+		}
+	}
+	return execs
+}
+
+func TestTaskSpecToPSteps_ImageEntrypoint(t *testing.T) {
+	c := &fakeClient{image: ocispecs.Image{
+		Config: ocispecs.ImageConfig{
+			Entrypoint: []string{"/bin/app"},
+			Cmd:        []string{"serve"},
+			User:       "1001",
+		},
+	}}
+	tests := []struct {
+		name     string
+		step     v1.Step
+		expected []string
+	}{{
+		name:     "no-command-no-args",
+		step:     v1.Step{Name: "run", Image: "example.com/app"},
+		expected: []string{"/bin/app", "serve"},
+	}, {
+		name:     "no-command-with-args",
+		step:     v1.Step{Name: "run", Image: "example.com/app", Args: []string{"version"}},
+		expected: []string{"/bin/app", "version"},
+	}, {
+		name:     "command-without-args",
+		step:     v1.Step{Name: "run", Image: "example.com/app", Command: []string{"/bin/other"}},
+		expected: []string{"/bin/other"},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			steps, err := taskSpecToPSteps(context.Background(), c, v1.TaskSpec{Steps: []v1.Step{tc.step}}, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			execs := stepExecs(t, steps)
+			if len(execs) != 1 {
+				t.Fatalf("expected 1 exec, got %d", len(execs))
+			}
+			args := execs[0].Meta.Args
+			// Strip the entrypoint binary arguments
+			for i, a := range args {
+				if a == "--" {
+					args = args[i+1:]
+					break
+				}
+			}
+			if d := cmp.Diff(tc.expected, args); d != "" {
+				t.Errorf("unexpected args: %s", d)
+			}
+		})
+	}
+}
+
+func TestTaskSpecToPSteps_ImageUser(t *testing.T) {
+	c := &fakeClient{image: ocispecs.Image{
+		Config: ocispecs.ImageConfig{
+			Entrypoint: []string{"/bin/app"},
+			User:       "1001",
+		},
+	}}
+	steps, err := taskSpecToPSteps(context.Background(), c, v1.TaskSpec{Steps: []v1.Step{{Name: "run", Image: "example.com/app"}}}, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, steps)
+	if user := execs[0].Meta.User; user != "1001" {
+		t.Errorf("expected step to run as the image user 1001, got %q", user)
+	}
+}
+
+func TestTaskSpecToPSteps_NoCommand(t *testing.T) {
+	c := &fakeClient{}
+	_, err := taskSpecToPSteps(context.Background(), c, v1.TaskSpec{Steps: []v1.Step{{Name: "run", Image: "example.com/app"}}}, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err == nil {
+		t.Fatalf("expected an error for a step without anything to run")
+	}
+}
+
+func TestTaskSpecToPSteps_ExpandArgs(t *testing.T) {
+	spec := v1.TaskSpec{Steps: []v1.Step{{
+		Name:    "run",
+		Image:   "alpine",
+		Command: []string{"echo", "$(GREETING)"},
+		Args:    []string{"$$(GREETING)", "$(UNKNOWN)"},
+		Env:     []corev1.EnvVar{{Name: "GREETING", Value: "hello"}},
+	}}}
+	steps, err := taskSpecToPSteps(context.Background(), &fakeClient{}, spec, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, steps)
+	args := execs[0].Meta.Args
+	if d := cmp.Diff([]string{"echo", "hello", "$(GREETING)", "$(UNKNOWN)"}, args[len(args)-4:]); d != "" {
+		t.Errorf("unexpected args: %s", d)
+	}
+}
+
+func TestTaskSpecToPSteps_OutputConfig(t *testing.T) {
+	// stdoutConfig and stderrConfig are alpha features
+	cfg := &config.Config{EntrypointImage: config.DefaultEntrypointImage()}
+	cfg.FeatureFlags.EnableAPIFields = "alpha"
+	ctx := cfg.ToContext(context.Background())
+	spec := v1.TaskSpec{
+		Results: []v1.TaskResult{{Name: "digest"}},
+		Steps: []v1.Step{{
+			Name:         "run",
+			Image:        "alpine",
+			Command:      []string{"sha256sum", "/etc/os-release"},
+			StdoutConfig: &v1.StepOutputConfig{Path: "$(results.digest.path)"},
+			StderrConfig: &v1.StepOutputConfig{Path: "/tekton/errors"},
+		}},
+	}
+	spec, err := applyTaskRunSubstitution(ctx, &v1.TaskRun{Spec: v1.TaskRunSpec{TaskSpec: &spec}}, &spec, "task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := taskSpecToPSteps(ctx, &fakeClient{}, spec, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, steps)
+	expected := []string{
+		entrypointBinary,
+		"-step_metadata_dir", "/tekton/steps/step-run",
+		"-stdout_path", "/tekton/results/digest",
+		"-stderr_path", "/tekton/errors",
+		"-results_dir", "/tekton/results",
+		"-clear_results",
+		"--", "sha256sum", "/etc/os-release",
+	}
+	if d := cmp.Diff(expected, execs[0].Meta.Args); d != "" {
+		t.Errorf("unexpected args: %s", d)
+	}
+}
+
+func TestTaskSpecToPSteps_ResultsAndTimeout(t *testing.T) {
+	// The Tekton defaults: results-from termination-message, max-result-size 4096
+	ctx := context.Background()
+	spec := v1.TaskSpec{
+		Results: []v1.TaskResult{{Name: "digest"}},
+		Steps: []v1.Step{{
+			Name:    "run",
+			Image:   "alpine",
+			Command: []string{"true"},
+		}},
+	}
+	timeout := firstTimeout(nil, &metav1.Duration{Duration: 5 * time.Minute}, &metav1.Duration{Duration: time.Hour})
+	steps, err := taskSpecToPSteps(ctx, &fakeClient{}, spec, location{}, "task", podMetadata{}, nil, timeout, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, steps)
+	expected := []string{
+		entrypointBinary,
+		"-step_metadata_dir", "/tekton/steps/step-run",
+		"-timeout", "5m0s",
+		"-results_dir", "/tekton/results",
+		"-clear_results",
+		"-results_from", "termination-message",
+		"-max_result_size", "4096",
+		"--", "true",
+	}
+	if d := cmp.Diff(expected, execs[0].Meta.Args); d != "" {
+		t.Errorf("unexpected args: %s", d)
+	}
+	// A zero timeout means no timeout
+	if timeout := firstTimeout(&metav1.Duration{}, &metav1.Duration{Duration: time.Hour}); timeout != nil {
+		t.Errorf("expected no timeout, got %s", timeout)
+	}
+}
+
+func TestTaskSpecToPSteps_PodLayout(t *testing.T) {
+	spec := v1.TaskSpec{Steps: []v1.Step{{
+		Name:    "first",
+		Image:   "alpine",
+		Command: []string{"touch", "/tekton/home/.config"},
+	}, {
+		Name:    "second",
+		Image:   "alpine",
+		Command: []string{"cat", "/tekton/home/.config"},
+		Env:     []corev1.EnvVar{{Name: "HOME", Value: "/root"}},
+	}}}
+	steps, err := taskSpecToPSteps(context.Background(), &fakeClient{}, spec, location{}, "task", podMetadata{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stepStates, err := pstepToState(&fakeClient{}, steps, llb.Scratch(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := stepStates[1].Marshal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same steps always give the same definition
+	again, err := stepStates[1].Marshal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(def.Def, again.Def); d != "" {
+		t.Errorf("unstable definition: %s", d)
+	}
+	execs := []*pb.ExecOp{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatal(err)
+		}
+		if exec := op.GetExec(); exec != nil {
+			execs = append(execs, exec)
+		}
+	}
+	if len(execs) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(execs))
+	}
+	expectedHome := []string{"HOME=/tekton/home", "HOME=/root"}
+	for i, exec := range execs {
+		// The pod directories start empty, then are passed from step to step
+		dirs := []string{}
+		for _, m := range exec.Mounts {
+			for _, dir := range podDirs {
+				if m.Dest != dir {
+					continue
+				}
+				dirs = append(dirs, m.Dest)
+				if m.MountType != pb.MountType_BIND || m.Output < 0 {
+					t.Errorf("step %d: expected %s to be a writable mount, got %+v", i, dir, m)
+				}
+				if fromPrevious := m.Input != int64(pb.Empty); fromPrevious != (i > 0) {
+					t.Errorf("step %d: unexpected input for %s: %d", i, dir, m.Input)
+				}
+			}
+		}
+		if d := cmp.Diff([]string{"/tekton/creds", "/tekton/home", "/tekton/run", "/tekton/steps", "/workspace"}, dirs); d != "" {
+			t.Errorf("step %d: unexpected shared mounts: %s", i, d)
+		}
+		home := ""
+		for _, e := range exec.Meta.Env {
+			if strings.HasPrefix(e, "HOME=") {
+				home = e
+			}
+		}
+		if home != expectedHome[i] {
+			t.Errorf("step %d: expected %s, got %s", i, expectedHome[i], home)
+		}
+	}
+}
@@ -7,25 +7,47 @@ import (
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/pkg/errors"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
 )
 
-// TektonToLLB returns a function that converts a string representing a Tekton resource
-// into a BuildKit LLB State.
-// Only support TaskRun with embedded Task to start.
-func TektonToLLB(c client.Client) func(context.Context, string, []string) (llb.State, error) {
-	return func(ctx context.Context, l string, refs []string) (llb.State, error) {
-		run, err := readResources(l, refs)
+// TektonToLLB returns a function that converts the main file (with the runs)
+// into a BuildKit LLB State, and the Tasks with sidecars (see
+// SolveWithSidecars), in the order they run.
+// When several runs are selected (see the run option), they are independent
+// graphs, merged in one state so that they are solved concurrently.
+func TektonToLLB(c client.Client) func(context.Context, ContextResource, []ContextResource) (llb.State, []TaskSidecars, error) {
+	return func(ctx context.Context, main ContextResource, refs []ContextResource) (llb.State, []TaskSidecars, error) {
+		runs, err := readResources(main, refs, config.FromContext(ctx).Run)
 		if err != nil {
-			return llb.State{}, errors.Wrap(err, "failed to read resources")
+			return llb.State{}, nil, errors.Wrap(err, "failed to read resources")
 		}
 
-		switch r := run.(type) {
-		case TaskRun:
-			return TaskRunToLLB(ctx, c, r)
-		case PipelineRun:
-			return PipelineRunToLLB(ctx, c, r)
-		default:
-			return llb.State{}, fmt.Errorf("Invalid state")
+		states := make([]llb.State, 0, len(runs))
+		sidecars := []TaskSidecars{}
+		for _, run := range runs {
+			st, s, err := runToLLB(ctx, c, run)
+			if err != nil {
+				return llb.State{}, nil, err
+			}
+			states = append(states, st)
+			sidecars = append(sidecars, s...)
 		}
+		if len(states) == 1 {
+			return states[0], sidecars, nil
+		}
+		return llb.Merge(states, llb.WithCustomName("[tekton] runs")), sidecars, nil
+	}
+}
+
+func runToLLB(ctx context.Context, c client.Client, run interface{}) (llb.State, []TaskSidecars, error) {
+	switch r := run.(type) {
+	case TaskRun:
+		st, sidecars, err := TaskRunToLLB(ctx, c, r)
+		return st, sidecars, errors.Wrapf(err, "taskrun %s", runName(r.main))
+	case PipelineRun:
+		st, sidecars, err := PipelineRunToLLB(ctx, c, r)
+		return st, sidecars, errors.Wrapf(err, "pipelinerun %s", runName(r.main))
+	default:
+		return llb.State{}, nil, fmt.Errorf("Invalid state")
 	}
 }
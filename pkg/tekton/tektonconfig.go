@@ -0,0 +1,110 @@
+package tekton
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	tektonconfig "github.com/tektoncd/pipeline/pkg/apis/config"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/tektoncd/pipeline/pkg/workspace"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// TektonNamespace is the namespace of the Tekton ConfigMaps detected in the
+// main file and the context files.
+const TektonNamespace = "tekton-pipelines"
+
+// WithTektonConfig applies the Tekton feature-flags and config-defaults
+// ConfigMaps found in the given resources (e.g. the main file and the context
+// files) to the configuration of the context. Unless namespace is empty, the
+// ConfigMaps of other namespaces are skipped. The first ConfigMap of each
+// name is used, files that cannot be decoded are skipped.
+func WithTektonConfig(ctx context.Context, resources []ContextResource, namespace string) (context.Context, error) {
+	cfg := *config.FromContext(ctx)
+	applied := map[string]string{}
+	for _, r := range resources {
+		configMaps, err := readConfigMaps(r.Data)
+		if err != nil {
+			logrus.Debugf("Skipping %s: %v", r.Path, err)
+			continue
+		}
+		for _, cm := range configMaps {
+			source := r.Path + ": " + cm.position
+			if namespace != "" && cm.Namespace != namespace {
+				logrus.Debugf("Skipping %s ConfigMap from %s, not in the %s namespace", cm.Name, source, namespace)
+				continue
+			}
+			if previous, ok := applied[cm.Name]; ok {
+				logrus.Warnf("Ignoring %s ConfigMap from %s, using the one from %s", cm.Name, source, previous)
+				continue
+			}
+			ok, err := cfg.ApplyConfigMap(cm.ConfigMap)
+			if err != nil {
+				return ctx, errors.Wrap(err, source)
+			}
+			if ok {
+				logrus.Infof("Using %s ConfigMap from %s", cm.Name, source)
+				applied[cm.Name] = source
+			}
+		}
+	}
+	return cfg.ToContext(ctx), nil
+}
+
+// positionedConfigMap is a ConfigMap with its position in its YAML stream.
+type positionedConfigMap struct {
+	*corev1.ConfigMap
+	position string
+}
+
+// readConfigMaps decodes the ConfigMaps of a multi-document YAML (or JSON)
+// stream, skipping any other document.
+func readConfigMaps(s string) ([]positionedConfigMap, error) {
+	lines := documentLines(s)
+	configMaps := []positionedConfigMap{}
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(s), 4096)
+	for i := 0; ; i++ {
+		position := documentPosition(i, lines)
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, position)
+		}
+		if len(doc) == 0 || string(doc) == "null" {
+			continue
+		}
+		cm := &corev1.ConfigMap{}
+		if err := json.Unmarshal(doc, cm); err != nil {
+			// Not a ConfigMap
+			continue
+		}
+		if cm.APIVersion != "v1" || cm.Kind != "ConfigMap" {
+			continue
+		}
+		configMaps = append(configMaps, positionedConfigMap{ConfigMap: cm, position: position})
+	}
+	return configMaps, nil
+}
+
+// validateCoschedule checks that the workspaces of a TaskRun (or of a
+// PipelineTask) can be coscheduled: with coschedule=workspaces, the affinity
+// assistant of Tekton requires a single PersistentVolumeClaim per TaskRun.
+// It is only checked when the feature-flags ConfigMap sets coschedule.
+func validateCoschedule(ctx context.Context, bindings []v1.WorkspaceBinding) error {
+	cfg := config.FromContext(ctx)
+	if !cfg.CoscheduleSet || cfg.FeatureFlags.Coschedule != tektonconfig.CoscheduleWorkspaces {
+		return nil
+	}
+	if err := workspace.ValidateOnlyOnePVCIsUsed(bindings); err != nil {
+		return errors.Wrap(err, "coschedule workspaces")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	tektonconfig "github.com/tektoncd/pipeline/pkg/apis/config"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestWithTektonConfig(t *testing.T) {
+	ctx, err := WithTektonConfig(context.Background(), []ContextResource{{
+		Path: "run.yaml",
+		Data: `apiVersion: tekton.dev/v1
+kind: TaskRun
+metadata:
+  name: run
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-defaults
+  namespace: other
+data:
+  default-timeout-minutes: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-defaults
+  namespace: tekton-pipelines
+data:
+  default-timeout-minutes: "5"
+`,
+	}, {
+		Path: "values.yaml",
+		Data: "image: [unclosed",
+	}, {
+		Path: "tekton/config.yaml",
+		Data: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: feature-flags
+  namespace: tekton-pipelines
+data:
+  results-from: sidecar-logs
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-defaults
+  namespace: tekton-pipelines
+data:
+  default-timeout-minutes: "10"
+`,
+	}}, TektonNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.FromContext(ctx)
+	// The first config-defaults ConfigMap of the namespace is used
+	if cfg.Defaults.DefaultTimeoutMinutes != 5 {
+		t.Errorf("expected default-timeout-minutes 5, got %d", cfg.Defaults.DefaultTimeoutMinutes)
+	}
+	if cfg.FeatureFlags.ResultExtractionMethod != tektonconfig.ResultExtractionMethodSidecarLogs {
+		t.Errorf("expected results-from sidecar-logs, got %s", cfg.FeatureFlags.ResultExtractionMethod)
+	}
+
+	// Without a namespace (the tekton-config file), any ConfigMap is used
+	ctx, err = WithTektonConfig(context.Background(), []ContextResource{{
+		Path: "config.yaml",
+		Data: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-defaults
+data:
+  default-timeout-minutes: "5"
+`,
+	}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minutes := config.FromContext(ctx).Defaults.DefaultTimeoutMinutes; minutes != 5 {
+		t.Errorf("expected default-timeout-minutes 5, got %d", minutes)
+	}
+
+	if _, err := WithTektonConfig(context.Background(), []ContextResource{{
+		Path: "config.yaml",
+		Data: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-defaults
+data:
+  default-timeout-minutes: "invalid"
+`,
+	}}, ""); err == nil {
+		t.Errorf("expected an error for an invalid config-defaults ConfigMap")
+	}
+}
+
+func TestValidateCoschedule(t *testing.T) {
+	bindings := []v1.WorkspaceBinding{{
+		Name:                  "source",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "source"},
+	}, {
+		Name:                  "cache",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "cache"},
+	}}
+	ctx := context.Background()
+	// The Tekton default (workspaces) is not checked
+	if err := validateCoschedule(ctx, bindings); err != nil {
+		t.Errorf("unexpected error without coschedule set: %v", err)
+	}
+	cfg := *config.FromContext(ctx)
+	cfg.CoscheduleSet = true
+	ctx = cfg.ToContext(ctx)
+	if err := validateCoschedule(ctx, bindings); err == nil {
+		t.Errorf("expected an error for two PersistentVolumeClaims with coschedule=workspaces")
+	}
+	if err := validateCoschedule(ctx, bindings[:1]); err != nil {
+		t.Errorf("unexpected error for a single PersistentVolumeClaim: %v", err)
+	}
+	cfg.FeatureFlags.Coschedule = tektonconfig.CoschedulePipelineRuns
+	if err := validateCoschedule(cfg.ToContext(ctx), bindings); err != nil {
+		t.Errorf("unexpected error with coschedule=pipelineruns: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package tekton
+
+import (
+	"path"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// HostPathPrefix is the prefix of the local context (or SSH socket, for
+// sockets) a client needs to share for a hostPath volume: a volume named
+// docker is mapped to the hostpath-docker local context. Host paths are never
+// shared implicitly.
+const HostPathPrefix = "hostpath-"
+
+// taskVolumes returns how to mount each of the volumes of a Task (named name).
+func taskVolumes(c client.Client, t v1.TaskSpec, name string, meta podMetadata, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (map[string]workspaceMountFn, error) {
+	volumes := make(map[string]workspaceMountFn, len(t.Volumes))
+	for _, vol := range t.Volumes {
+		fn, err := taskVolume(c, vol, name, meta, configs, secrets)
+		if err != nil {
+			return nil, errors.Wrapf(err, "volume %s", vol.Name)
+		}
+		volumes[vol.Name] = fn
+	}
+	return volumes, nil
+}
+
+func taskVolume(c client.Client, vol corev1.Volume, name string, meta podMetadata, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (workspaceMountFn, error) {
+	switch {
+	case vol.EmptyDir != nil:
+		// Use a persistent cache for emptyDir to share data between steps
+		volName := vol.Name
+		return func(target, subPath string, readOnly bool) mountOptionFn {
+			return func(state llb.State) llb.RunOption {
+				opts := []llb.MountOption{
+					llb.AsPersistentCacheDir(name+"/volume/"+volName, llb.CacheMountShared),
+				}
+				if subPath != "" {
+					opts = append(opts, llb.SourcePath(subPath))
+				}
+				if readOnly {
+					opts = append(opts, llb.Readonly)
+				}
+				return llb.AddMount(target, state, opts...)
+			}
+		}, nil
+	case vol.ConfigMap != nil:
+		st, err := files.ConfigMap(configs[vol.ConfigMap.Name], vol.ConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		return readOnlyMount(st, ""), nil
+	case vol.Secret != nil:
+		st, err := files.Secret(secrets[vol.Secret.SecretName], vol.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return readOnlyMount(st, ""), nil
+	case vol.DownwardAPI != nil:
+		st, err := files.DownwardAPI(vol.Name, vol.DownwardAPI, meta.fieldValue)
+		if err != nil {
+			return nil, err
+		}
+		return readOnlyMount(st, ""), nil
+	case vol.HostPath != nil:
+		return hostPathVolume(c, vol.Name, vol.HostPath), nil
+	}
+	return nil, errors.New("only emptyDir, configMap, secret, downwardAPI and hostPath volumes are supported")
+}
+
+// hostPathVolume maps a hostPath volume to what the client explicitly shares
+// for it: an SSH socket for sockets (e.g. the docker socket), a local context
+// otherwise. A local context is a copy, changes are not written back.
+func hostPathVolume(c client.Client, name string, source *corev1.HostPathVolumeSource) workspaceMountFn {
+	id := HostPathPrefix + name
+	if isSocket(source) {
+		return func(target, _ string, _ bool) mountOptionFn {
+			return func(_ llb.State) llb.RunOption {
+				return llb.AddSSHSocket(llb.SSHID(id), llb.SSHSocketTarget(target))
+			}
+		}
+	}
+	return func(target, subPath string, readOnly bool) mountOptionFn {
+		return func(_ llb.State) llb.RunOption {
+			st := llb.Local(id,
+				llb.SessionID(c.BuildOpts().SessionID),
+				llb.SharedKeyHint(id),
+				llb.WithCustomName("[tekton] load host path "+source.Path),
+			)
+			opts := []llb.MountOption{llb.SourcePath(path.Join("/", subPath))}
+			if readOnly {
+				opts = append(opts, llb.Readonly)
+			}
+			return llb.AddMount(target, st, opts...)
+		}
+	}
+}
+
+func isSocket(source *corev1.HostPathVolumeSource) bool {
+	if source.Type != nil && *source.Type == corev1.HostPathSocket {
+		return true
+	}
+	return strings.HasSuffix(source.Path, ".sock")
+}
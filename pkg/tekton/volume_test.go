@@ -0,0 +1,79 @@
+package tekton
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTaskVolumes(t *testing.T) {
+	socket := corev1.HostPathSocket
+	spec := v1.TaskSpec{Volumes: []corev1.Volume{{
+		Name:         "cache",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}, {
+		Name: "podinfo",
+		VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+			Items: []corev1.DownwardAPIVolumeFile{{
+				Path:     "labels",
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"},
+			}},
+		}},
+	}, {
+		Name:         "docker",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run/docker.sock", Type: &socket}},
+	}}}
+	meta := podMetadata{labels: map[string]string{"app": "demo"}}
+	volumes, err := taskVolumes(&fakeClient{}, spec, "task", meta, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOptions := []llb.RunOption{llb.Args([]string{"true"})}
+	for name, target := range map[string]string{"cache": "/cache", "podinfo": "/etc/podinfo", "docker": "/var/run/docker.sock"} {
+		runOptions = append(runOptions, volumes[name](target, "", false)(llb.Scratch()))
+	}
+	execs := stepExecs(t, []pstep{{runOptions: runOptions}})
+	mounts := map[string]*pb.Mount{}
+	for _, m := range execs[0].Mounts {
+		mounts[m.Dest] = m
+	}
+	if m, ok := mounts["/cache"]; !ok || m.MountType != pb.MountType_CACHE || m.CacheOpt.ID != "task/volume/cache" {
+		t.Errorf("unexpected emptyDir mount: %+v", m)
+	}
+	if m, ok := mounts["/etc/podinfo"]; !ok || m.MountType != pb.MountType_BIND || !m.Readonly {
+		t.Errorf("unexpected downwardAPI mount: %+v", m)
+	}
+	if m, ok := mounts["/var/run/docker.sock"]; !ok || m.MountType != pb.MountType_SSH || m.SSHOpt.ID != "hostpath-docker" {
+		t.Errorf("unexpected hostPath mount: %+v", m)
+	}
+}
+
+func TestTaskVolumesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source corev1.VolumeSource
+	}{{
+		name:   "missing-configmap",
+		source: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+	}, {
+		name: "downward-resource-field-ref",
+		source: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+			Items: []corev1.DownwardAPIVolumeFile{{Path: "cpu", ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.cpu"}}},
+		}},
+	}, {
+		name:   "nfs",
+		source: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "server", Path: "/"}},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			spec := v1.TaskSpec{Volumes: []corev1.Volume{{Name: "vol", VolumeSource: tc.source}}}
+			if _, err := taskVolumes(&fakeClient{}, spec, "task", podMetadata{}, nil, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
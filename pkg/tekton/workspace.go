@@ -0,0 +1,288 @@
+package tekton
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/dockerui"
+	"github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	"github.com/vdemeester/buildkit-tekton/pkg/tekton/files"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContextBindingPrefix is the prefix of a workspace option binding to a
+// BuildKit named context (e.g. --opt workspace:source=context:src), see
+// namedContext.
+const ContextBindingPrefix = "context:"
+
+// workspaceMountFn returns the mount of a bound workspace at target, using
+// the given subPath of the binding (if any).
+type workspaceMountFn func(target, subPath string, readOnly bool) mountOptionFn
+
+// bindWorkspaces returns how to mount each of the given workspace bindings,
+// of a TaskRun or PipelineRun named runName.
+func bindWorkspaces(bindings []v1.WorkspaceBinding, runName string, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (map[string]workspaceMountFn, error) {
+	workspaces := make(map[string]workspaceMountFn, len(bindings))
+	for _, w := range bindings {
+		fn, err := bindWorkspace(w, runName, configs, secrets)
+		if err != nil {
+			return nil, errors.Wrapf(err, "workspace %s", w.Name)
+		}
+		workspaces[w.Name] = fn
+	}
+	return workspaces, nil
+}
+
+func bindWorkspace(w v1.WorkspaceBinding, runName string, configs map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret) (workspaceMountFn, error) {
+	switch {
+	case w.ConfigMap != nil:
+		st, err := files.ConfigMap(configs[w.ConfigMap.Name], w.ConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		return readOnlyMount(st, w.SubPath), nil
+	case w.Secret != nil:
+		st, err := files.Secret(secrets[w.Secret.SecretName], w.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return readOnlyMount(st, w.SubPath), nil
+	case w.EmptyDir != nil,
+		w.VolumeClaimTemplate != nil,
+		w.PersistentVolumeClaim != nil:
+		// Volumes are emulated with a persistent cache, shared by all the
+		// Tasks of the run. As a cache mount can't be mounted from a
+		// sub-directory, each subPath gets its own cache.
+		claimReadOnly := w.PersistentVolumeClaim != nil && w.PersistentVolumeClaim.ReadOnly
+		return func(target, subPath string, readOnly bool) mountOptionFn {
+			return func(state llb.State) llb.RunOption {
+				opts := []llb.MountOption{
+					llb.AsPersistentCacheDir(path.Join(runName, w.Name, w.SubPath, subPath), llb.CacheMountShared),
+				}
+				if readOnly || claimReadOnly {
+					opts = append(opts, llb.Readonly)
+				}
+				return llb.AddMount(target, state, opts...)
+			}
+		}, nil
+	case w.Projected != nil:
+		return nil, errors.New("projected workspaces are not supported")
+	case w.CSI != nil:
+		return nil, errors.New("csi workspaces are not supported")
+	}
+	return nil, errors.New("no volume source")
+}
+
+// overrideWorkspaces applies the bindings of the workspace option to the
+// workspace bindings of a run. It returns the bindings, and the context of
+// each workspace bound to one, which is bound to an emptyDir placeholder.
+func overrideWorkspaces(bindings []v1.WorkspaceBinding, overrides map[string]string) ([]v1.WorkspaceBinding, map[string]string, error) {
+	contexts := map[string]string{}
+	if len(overrides) == 0 {
+		return bindings, contexts, nil
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := append([]v1.WorkspaceBinding{}, bindings...)
+	for _, name := range names {
+		binding, contextName, err := parseWorkspaceOption(name, overrides[name])
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "workspace %s", name)
+		}
+		if contextName != "" {
+			contexts[name] = contextName
+		}
+		result = setWorkspaceBinding(result, binding)
+	}
+	return result, contexts, nil
+}
+
+// parseWorkspaceOption parses a binding of the workspace option: emptyDir,
+// configMap:<name>, secret:<name>, pvc:<claim> or context:<name>. It returns
+// the name of the context for the latter.
+func parseWorkspaceOption(name, value string) (v1.WorkspaceBinding, string, error) {
+	binding := v1.WorkspaceBinding{Name: name}
+	kind, ref, _ := strings.Cut(value, ":")
+	if kind == "emptyDir" && ref == "" {
+		binding.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		return binding, "", nil
+	}
+	if ref == "" {
+		return binding, "", errors.Errorf("invalid binding %q, expected emptyDir, configMap:<name>, secret:<name>, pvc:<claim> or context:<name>", value)
+	}
+	switch kind {
+	case "configMap":
+		binding.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: ref}}
+	case "secret":
+		binding.Secret = &corev1.SecretVolumeSource{SecretName: ref}
+	case "pvc":
+		binding.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: ref}
+	case strings.TrimSuffix(ContextBindingPrefix, ":"):
+		binding.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		return binding, ref, nil
+	default:
+		return binding, "", errors.Errorf("invalid binding %q, expected emptyDir, configMap:<name>, secret:<name>, pvc:<claim> or context:<name>", value)
+	}
+	return binding, "", nil
+}
+
+// setWorkspaceBinding replaces the binding of the same name, or adds it.
+func setWorkspaceBinding(bindings []v1.WorkspaceBinding, binding v1.WorkspaceBinding) []v1.WorkspaceBinding {
+	for i := range bindings {
+		if bindings[i].Name == binding.Name {
+			bindings[i] = binding
+			return bindings
+		}
+	}
+	return append(bindings, binding)
+}
+
+// bindContextWorkspaces binds the workspaces bound to a context (see
+// overrideWorkspaces), of a run named runName.
+func bindContextWorkspaces(c client.Client, workspaces map[string]workspaceMountFn, bindings []v1.WorkspaceBinding, contexts map[string]string, runName string) error {
+	for _, w := range bindings {
+		contextName, ok := contexts[w.Name]
+		if !ok {
+			continue
+		}
+		st, err := namedContext(c, contextName)
+		if err != nil {
+			return errors.Wrapf(err, "workspace %s", w.Name)
+		}
+		workspaces[w.Name] = contextWorkspace(st, runName, w)
+	}
+	return nil
+}
+
+// namedContext returns the state of a named context. Like with the Dockerfile
+// frontend, the context:<name> option (e.g. docker buildx build
+// --build-context) gives its source, as local:<name>, docker-image://<ref> or
+// a git URL. Without it, the context is the local context of the same name.
+func namedContext(c client.Client, name string) (llb.State, error) {
+	value, ok := c.BuildOpts().Opts["context:"+name]
+	if !ok {
+		value = "local:" + name
+	}
+	switch {
+	case strings.HasPrefix(value, "local:"):
+		localName := strings.TrimPrefix(value, "local:")
+		return llb.Local(localName,
+			llb.SessionID(c.BuildOpts().SessionID),
+			llb.SharedKeyHint(localName),
+			llb.WithCustomName("[tekton] load context "+name),
+		), nil
+	case strings.HasPrefix(value, "docker-image://"):
+		ref := strings.TrimPrefix(value, "docker-image://")
+		return llb.Image(ref, llb.WithCustomName("[tekton] load context "+name+" from "+ref)), nil
+	}
+	st, isGit, err := dockerui.DetectGitContext(value, nil)
+	if err != nil {
+		return llb.State{}, errors.Wrapf(err, "context %s", name)
+	}
+	if !isGit {
+		return llb.State{}, errors.Errorf("context %s: unsupported source %q, expected local:<name>, docker-image://<ref> or a git URL", name, value)
+	}
+	return *st, nil
+}
+
+// contextWorkspace mounts a named context as a workspace. The context is the
+// base of a persistent cache (keyed by the context content), so that writes
+// are shared by the steps and Tasks of the run, without changing the context.
+func contextWorkspace(st llb.State, runName string, w v1.WorkspaceBinding) workspaceMountFn {
+	return func(target, subPath string, readOnly bool) mountOptionFn {
+		return func(state llb.State) llb.RunOption {
+			src := st
+			// As a cache mount can't be mounted from a sub-directory, the
+			// sub-directory is copied
+			if p := path.Join("/", w.SubPath, subPath); p != "/" {
+				src = llb.Scratch().File(llb.Copy(st, p, "/", &llb.CopyInfo{CopyDirContentsOnly: true}))
+			}
+			opts := []llb.MountOption{
+				llb.AsPersistentCacheDir(path.Join(runName, w.Name, w.SubPath, subPath), llb.CacheMountShared),
+			}
+			if readOnly {
+				opts = append(opts, llb.Readonly)
+			}
+			return llb.AddMount(target, src, opts...)
+		}
+	}
+}
+
+// readOnlyMount mounts the given (ConfigMap, Secret or downward API) state,
+// which is always read-only.
+func readOnlyMount(st llb.State, bindingSubPath string) workspaceMountFn {
+	return func(target, subPath string, _ bool) mountOptionFn {
+		return func(_ llb.State) llb.RunOption {
+			return llb.AddMount(target, st, llb.SourcePath(path.Join("/", bindingSubPath, subPath)), llb.Readonly)
+		}
+	}
+}
+
+// boundTaskWorkspace is a workspace bound to a Task, either by a TaskRun or
+// by a PipelineTask (using a sub-path of a PipelineRun workspace).
+type boundTaskWorkspace struct {
+	mount   workspaceMountFn
+	subPath string
+}
+
+// taskRunBoundWorkspaces returns the workspaces bound by a TaskRun.
+func taskRunBoundWorkspaces(bindings []v1.WorkspaceBinding, workspaces map[string]workspaceMountFn) map[string]boundTaskWorkspace {
+	bound := make(map[string]boundTaskWorkspace, len(bindings))
+	for _, w := range bindings {
+		bound[w.Name] = boundTaskWorkspace{mount: workspaces[w.Name]}
+	}
+	return bound
+}
+
+// pipelineTaskBoundWorkspaces returns the workspaces bound by a PipelineTask,
+// and the equivalent TaskRun bindings (used for variable substitution).
+// Workspaces bound to an unbound (optional) Pipeline workspace are skipped.
+func pipelineTaskBoundWorkspaces(pt v1.PipelineTask, bindings []v1.WorkspaceBinding, workspaces map[string]workspaceMountFn) (map[string]boundTaskWorkspace, []v1.WorkspaceBinding) {
+	pipelineBindings := make(map[string]v1.WorkspaceBinding, len(bindings))
+	for _, b := range bindings {
+		pipelineBindings[b.Name] = b
+	}
+	bound := make(map[string]boundTaskWorkspace, len(pt.Workspaces))
+	taskBindings := []v1.WorkspaceBinding{}
+	for _, w := range pt.Workspaces {
+		name := w.Workspace
+		if name == "" {
+			name = w.Name
+		}
+		fn, ok := workspaces[name]
+		if !ok {
+			continue
+		}
+		bound[w.Name] = boundTaskWorkspace{mount: fn, subPath: w.SubPath}
+		b := pipelineBindings[name]
+		b.Name = w.Name
+		b.SubPath = path.Join(b.SubPath, w.SubPath)
+		taskBindings = append(taskBindings, b)
+	}
+	return bound, taskBindings
+}
+
+// taskWorkspaceMounts returns the mounts of the workspaces declared by a Task,
+// honouring their mountPath and readOnly. Unbound optional workspaces are not
+// mounted.
+func taskWorkspaceMounts(declarations []v1.WorkspaceDeclaration, bound map[string]boundTaskWorkspace) ([]mountOptionFn, error) {
+	mounts := []mountOptionFn{}
+	for _, d := range declarations {
+		w, ok := bound[d.Name]
+		if !ok || w.mount == nil {
+			if d.Optional {
+				continue
+			}
+			return nil, errors.Errorf("workspace %s is not bound", d.Name)
+		}
+		mounts = append(mounts, w.mount(d.GetMountPath(), w.subPath, d.ReadOnly))
+	}
+	return mounts, nil
+}
@@ -0,0 +1,271 @@
+package tekton
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	v1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBindWorkspaces(t *testing.T) {
+	configs := map[string]*corev1.ConfigMap{
+		"config": {ObjectMeta: metav1.ObjectMeta{Name: "config"}, Data: map[string]string{"key": "value"}},
+	}
+	secrets := map[string]*corev1.Secret{
+		"secret": {ObjectMeta: metav1.ObjectMeta{Name: "secret"}, Data: map[string][]byte{"key": []byte("value")}},
+	}
+	tests := []struct {
+		name     string
+		binding  v1.WorkspaceBinding
+		subPath  string
+		readOnly bool
+		check    func(t *testing.T, m *pb.Mount)
+	}{{
+		name: "configmap",
+		binding: v1.WorkspaceBinding{
+			Name:      "ws",
+			SubPath:   "dir",
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}},
+		},
+		check: func(t *testing.T, m *pb.Mount) {
+			if m.MountType != pb.MountType_BIND || !m.Readonly || m.Selector != "/dir" {
+				t.Errorf("unexpected configmap mount: %+v", m)
+			}
+		},
+	}, {
+		name: "secret",
+		binding: v1.WorkspaceBinding{
+			Name:   "ws",
+			Secret: &corev1.SecretVolumeSource{SecretName: "secret"},
+		},
+		check: func(t *testing.T, m *pb.Mount) {
+			if m.MountType != pb.MountType_BIND || !m.Readonly {
+				t.Errorf("unexpected secret mount: %+v", m)
+			}
+		},
+	}, {
+		name: "emptydir",
+		binding: v1.WorkspaceBinding{
+			Name:     "ws",
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+		subPath: "sub",
+		check: func(t *testing.T, m *pb.Mount) {
+			if m.MountType != pb.MountType_CACHE || m.CacheOpt.ID != "run/ws/sub" || m.Readonly {
+				t.Errorf("unexpected emptyDir mount: %+v", m)
+			}
+		},
+	}, {
+		name: "pvc-read-only",
+		binding: v1.WorkspaceBinding{
+			Name:                  "ws",
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "claim"},
+		},
+		readOnly: true,
+		check: func(t *testing.T, m *pb.Mount) {
+			if m.MountType != pb.MountType_CACHE || m.CacheOpt.ID != "run/ws" || !m.Readonly {
+				t.Errorf("unexpected pvc mount: %+v", m)
+			}
+		},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			workspaces, err := bindWorkspaces([]v1.WorkspaceBinding{tc.binding}, "run", configs, secrets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mount := workspaces["ws"]("/workspace/ws", tc.subPath, tc.readOnly)
+			execs := stepExecs(t, []pstep{{runOptions: []llb.RunOption{llb.Args([]string{"true"}), mount(llb.Scratch())}}})
+			for _, m := range execs[0].Mounts {
+				if m.Dest == "/workspace/ws" {
+					tc.check(t, m)
+					return
+				}
+			}
+			t.Fatalf("workspace not mounted")
+		})
+	}
+}
+
+func TestBindWorkspacesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding v1.WorkspaceBinding
+	}{{
+		name:    "missing-configmap",
+		binding: v1.WorkspaceBinding{Name: "ws", ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+	}, {
+		name:    "projected",
+		binding: v1.WorkspaceBinding{Name: "ws", Projected: &corev1.ProjectedVolumeSource{}},
+	}, {
+		name:    "csi",
+		binding: v1.WorkspaceBinding{Name: "ws", CSI: &corev1.CSIVolumeSource{Driver: "driver"}},
+	}}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := bindWorkspaces([]v1.WorkspaceBinding{tc.binding}, "run", nil, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestTaskWorkspaceMounts(t *testing.T) {
+	workspaces, err := bindWorkspaces([]v1.WorkspaceBinding{{
+		Name:     "shared",
+		EmptyDir: &corev1.EmptyDirVolumeSource{},
+	}}, "run", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := v1.PipelineTask{
+		Name: "task",
+		Workspaces: []v1.WorkspacePipelineTaskBinding{{
+			Name:      "source",
+			Workspace: "shared",
+			SubPath:   "src",
+		}, {
+			Name:      "cache",
+			Workspace: "unbound",
+		}},
+	}
+	bound, bindings := pipelineTaskBoundWorkspaces(pt, []v1.WorkspaceBinding{{Name: "shared", EmptyDir: &corev1.EmptyDirVolumeSource{}}}, workspaces)
+	if len(bindings) != 1 || bindings[0].Name != "source" || bindings[0].SubPath != "src" || bindings[0].EmptyDir == nil {
+		t.Errorf("unexpected task bindings: %+v", bindings)
+	}
+
+	declarations := []v1.WorkspaceDeclaration{
+		{Name: "source", MountPath: "/src", ReadOnly: true},
+		{Name: "cache", Optional: true},
+	}
+	mounts, err := taskWorkspaceMounts(declarations, bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 {
+		t.Fatalf("expected 1 mount, got %d", len(mounts))
+	}
+	execs := stepExecs(t, []pstep{{runOptions: []llb.RunOption{llb.Args([]string{"true"}), mounts[0](llb.Scratch())}}})
+	found := false
+	for _, m := range execs[0].Mounts {
+		if m.Dest == "/src" {
+			found = true
+			if m.CacheOpt.ID != "run/shared/src" || !m.Readonly {
+				t.Errorf("unexpected mount: %+v", m)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the workspace to be mounted at its declared mountPath")
+	}
+
+	declarations[1].Optional = false
+	if _, err := taskWorkspaceMounts(declarations, bound); err == nil {
+		t.Errorf("expected an error for an unbound required workspace")
+	}
+}
+
+func TestOverrideWorkspaces(t *testing.T) {
+	bindings := []v1.WorkspaceBinding{
+		{Name: "source", PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc"}},
+		{Name: "cache", EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+	got, contexts, err := overrideWorkspaces(bindings, map[string]string{
+		"source": "context:src",
+		"extra":  "context:extra",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Name != "source" || got[0].EmptyDir == nil || got[0].PersistentVolumeClaim != nil || got[2].Name != "extra" {
+		t.Errorf("unexpected bindings: %+v", got)
+	}
+	if bindings[0].PersistentVolumeClaim == nil {
+		t.Errorf("the run bindings should not be modified")
+	}
+	if contexts["source"] != "src" || contexts["extra"] != "extra" {
+		t.Errorf("unexpected contexts: %v", contexts)
+	}
+	for _, invalid := range []string{"unknown:src", "context:", "configMap", "emptyDir:foo"} {
+		if _, _, err := overrideWorkspaces(bindings, map[string]string{"source": invalid}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+	others, _, err := overrideWorkspaces(nil, map[string]string{
+		"a": "emptyDir",
+		"b": "configMap:config",
+		"c": "secret:secret",
+		"d": "pvc:claim",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(others) != 4 || others[0].EmptyDir == nil || others[1].ConfigMap.Name != "config" || others[2].Secret.SecretName != "secret" || others[3].PersistentVolumeClaim.ClaimName != "claim" {
+		t.Errorf("unexpected bindings: %+v", others)
+	}
+
+	workspaces := map[string]workspaceMountFn{}
+	if err := bindContextWorkspaces(&fakeClient{}, workspaces, got, contexts, "run"); err != nil {
+		t.Fatal(err)
+	}
+	execs := stepExecs(t, []pstep{{runOptions: []llb.RunOption{llb.Args([]string{"true"}), workspaces["source"]("/workspace/source", "", false)(llb.Scratch())}}})
+	found := false
+	for _, m := range execs[0].Mounts {
+		if m.Dest == "/workspace/source" {
+			found = true
+			if m.MountType != pb.MountType_CACHE || m.CacheOpt.ID != "run/source" || m.Input < 0 {
+				t.Errorf("unexpected mount: %+v", m)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the context workspace to be mounted")
+	}
+}
+
+func TestNamedContext(t *testing.T) {
+	c := &fakeClient{opts: map[string]string{
+		"context:local": "local:src",
+		"context:image": "docker-image://alpine:3.20",
+		"context:git":   "https://github.com/tektoncd/catalog.git#main",
+		"context:http":  "https://example.com/archive.tar.gz",
+	}}
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "local", expected: "local://src"},
+		{name: "unset", expected: "local://unset"},
+		{name: "image", expected: "docker-image://docker.io/library/alpine:3.20"},
+		{name: "git", expected: "git://github.com/tektoncd/catalog.git#main"},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := namedContext(c, tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			def, err := st.Marshal(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var op pb.Op
+			if err := op.Unmarshal(def.Def[len(def.Def)-2]); err != nil {
+				t.Fatal(err)
+			}
+			if op.GetSource() == nil || op.GetSource().Identifier != tc.expected {
+				t.Errorf("expected source %s, got %+v", tc.expected, op.GetSource())
+			}
+		})
+	}
+	if _, err := namedContext(c, "http"); err == nil {
+		t.Errorf("expected an error for an unsupported source")
+	}
+}